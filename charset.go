@@ -0,0 +1,129 @@
+package xmlparser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// sniff detects the encoding of the input from a byte order mark or,
+// for UTF-16 without one, from the "<?" of the XML declaration. UTF-16
+// input is converted to UTF-8 before it reaches the scanners, while
+// positions keep counting its bytes.
+func (xr *xmlReader) sniff() {
+	xr.sniffed = true
+	b, _ := xr.r.Peek(4)
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		xr.r.Discard(3)
+		xr.at.Offset = 3
+		xr.detected = "UTF-8"
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		xr.r.Discard(2)
+		xr.at.Offset = 2
+		xr.fromUTF16(true)
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		xr.r.Discard(2)
+		xr.at.Offset = 2
+		xr.fromUTF16(false)
+	case bytes.Equal(b, []byte{0, '<', 0, '?'}):
+		xr.fromUTF16(true)
+	case bytes.Equal(b, []byte{'<', 0, '?', 0}):
+		xr.fromUTF16(false)
+	}
+}
+
+func (xr *xmlReader) fromUTF16(bigEndian bool) {
+	xr.detected = "UTF-16"
+	xr.at.wide = true
+	xr.r = bufReader(&utf16Reader{r: xr.r, be: bigEndian}, xr.r.Size())
+}
+
+// charset switches to the encoding name declared by the XML
+// declaration, or returns what is wrong with it.
+func (xr *xmlReader) charset(name string) string {
+	xr.release()
+	upper := strings.ToUpper(name)
+	switch xr.detected {
+	case "UTF-16":
+		if upper != "" && upper != "UTF-16" && upper != "UTF-16LE" && upper != "UTF-16BE" {
+			return "encoding differs from the UTF-16 input"
+		}
+		return ""
+	case "UTF-8":
+		if upper != "" && upper != "UTF-8" && upper != "UTF8" {
+			return "encoding differs from the UTF-8 byte order mark"
+		}
+	}
+
+	switch upper {
+	case "", "UTF-8", "UTF8":
+		xr.enc = encUTF8
+	case "ISO-8859-1", "LATIN1", "ISO_8859-1", "L1":
+		xr.enc = encLatin1
+	case "US-ASCII", "ASCII":
+		xr.enc = encASCII
+	default:
+		if xr.opt.charsetReader == nil {
+			return "unsupported encoding"
+		}
+		r, err := xr.opt.charsetReader(name, xr.r)
+		if err != nil {
+			return err.Error()
+		}
+		xr.r = bufReader(r, xr.r.Size())
+		xr.enc = encUTF8
+	}
+	xr.plain = xr.enc == encUTF8
+	return ""
+}
+
+// utf16Reader converts UTF-16 input to UTF-8.
+type utf16Reader struct {
+	r    *bufio.Reader
+	be   bool   // big endian
+	rest []byte // UTF-8 bytes of a rune which did not fit into the last Read
+}
+
+func (u *utf16Reader) unit() (rune, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		return 0, err
+	}
+	if u.be {
+		return rune(b[0])<<8 | rune(b[1]), nil
+	}
+	return rune(b[1])<<8 | rune(b[0]), nil
+}
+
+// Read converts at least one rune and then as many as are buffered,
+// so that it does not block on input the scanners have not asked for.
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := copy(p, u.rest)
+	u.rest = u.rest[n:]
+	for n < len(p) && (n == 0 || u.r.Buffered() >= 4) {
+		c, err := u.unit()
+		if err != nil {
+			return n, err
+		}
+		if utf16.IsSurrogate(c) {
+			c2, err := u.unit()
+			if err != nil {
+				return n, err
+			}
+			c = utf16.DecodeRune(c, c2)
+		}
+
+		var b [utf8.UTFMax]byte
+		size := utf8.EncodeRune(b[:], c)
+		m := copy(p[n:], b[:size])
+		n += m
+		if m < size {
+			u.rest = append(u.rest[:0], b[m:size]...)
+		}
+	}
+	return n, nil
+}
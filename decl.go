@@ -0,0 +1,157 @@
+package xmlparser
+
+import (
+	"strings"
+)
+
+// XmlDecl is the XML declaration <?xml ...?> at the start of a document.
+type XmlDecl struct {
+	Version    string // "1.0"
+	Encoding   string // "" if not declared
+	Standalone string // "yes", "no" or "" if not declared
+}
+
+// parseXmlDecl parses the text between "<?" and "?>" of an XML
+// declaration. start is the input position of raw[0].
+// Values may be quoted or, for compatibility, bare words.
+func parseXmlDecl(raw string, start Pos) (*XmlDecl, error) {
+	declErr := func(i int, found, expected string) error {
+		return &SyntaxError{
+			Pos:      start.advance(raw[:i]),
+			State:    "decl",
+			Found:    found,
+			Expected: expected,
+			Excerpt:  caretExcerpt("<?"+raw[:i], raw[i:]+"?>"),
+		}
+	}
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+	}
+
+	if !strings.HasPrefix(raw, "xml") || len(raw) > 3 && !isSpace(raw[3]) {
+		return nil, declErr(0, raw, `"xml"`)
+	}
+
+	decl := &XmlDecl{}
+	order := []string{"version", "encoding", "standalone"}
+	i := 3
+	for {
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i == len(raw) {
+			break
+		}
+		if i > 0 && !isSpace(raw[i-1]) {
+			return nil, declErr(i, raw[i:i+1], "white space")
+		}
+
+		// name
+		k := i
+		for i < len(raw) && raw[i] != '=' && !isSpace(raw[i]) {
+			i++
+		}
+		name := raw[k:i]
+		n := 0
+		for n < len(order) && order[n] != name {
+			n++
+		}
+		if decl.Version == "" && name != "version" {
+			return nil, declErr(k, name, "version")
+		} else if n == len(order) && len(order) == 0 {
+			return nil, declErr(k, name, `"?>"`)
+		} else if n == len(order) {
+			return nil, declErr(k, name, strings.Join(order, " or "))
+		}
+		order = order[n+1:]
+
+		// '='
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i == len(raw) || raw[i] != '=' {
+			return nil, declErr(i, raw[i:], "'='")
+		}
+		i++
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+
+		// value
+		v := i
+		var value string
+		if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+			end := strings.IndexByte(raw[i+1:], raw[i])
+			if end < 0 {
+				return nil, declErr(len(raw), "", "closing quote")
+			}
+			value = raw[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			for i < len(raw) && !isSpace(raw[i]) {
+				i++
+			}
+			value = raw[v:i]
+		}
+
+		switch name {
+		case "version":
+			if !validVersion(value) {
+				return nil, declErr(v, raw[v:i], "version 1.x")
+			}
+			decl.Version = value
+		case "encoding":
+			if !validEncName(value) {
+				return nil, declErr(v, raw[v:i], "an encoding name")
+			}
+			decl.Encoding = value
+		case "standalone":
+			if value != "yes" && value != "no" {
+				return nil, declErr(v, raw[v:i], `"yes" or "no"`)
+			}
+			decl.Standalone = value
+		}
+	}
+
+	if decl.Version == "" {
+		return nil, declErr(len(raw), "", "version")
+	}
+	return decl, nil
+}
+
+// validVersion matches VersionNum: '1.' [0-9]+
+func validVersion(v string) bool {
+	if len(v) < 3 || v[:2] != "1." {
+		return false
+	}
+	for i := 2; i < len(v); i++ {
+		if v[i] < '0' || v[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validEncName matches EncName: [A-Za-z] ([A-Za-z0-9._] | '-')*
+func validEncName(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return v != ""
+}
+
+// splitPI splits the text between "<?" and "?>" of a processing
+// instruction into its target and data.
+func splitPI(raw string) (target, data string) {
+	i := strings.IndexAny(raw, " \t\r\n")
+	if i < 0 {
+		return raw, ""
+	}
+	return raw[:i], strings.TrimLeft(raw[i:], " \t\r\n")
+}
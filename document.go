@@ -0,0 +1,138 @@
+package xmlparser
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// errors returned for a tree which is not a single document
+var (
+	ErrNoRoot    = errors.New("xmlparser: document has no root element")
+	ErrManyRoots = errors.New("xmlparser: document has more than one root element")
+	ErrOutside   = errors.New("xmlparser: text outside the root element")
+)
+
+// Document is an XML document with the markup around its root element
+// kept apart from it.
+type Document struct {
+	Decl    *XmlDecl   // XML declaration, nil if none
+	Doctype *Doctype   // DOCTYPE declaration, nil if none
+	Prolog  []*XmlNode // comments, PIs and white space before the root element
+	Epilog  []*XmlNode // comments, PIs and white space after the root element
+
+	root  *XmlNode
+	dtPos int // number of Prolog nodes before the DOCTYPE
+}
+
+// NewDocument returns a document with the root element root, which is
+// taken out of the tree it is in.
+func NewDocument(root *XmlNode) (*Document, error) {
+	doc := &Document{}
+	if err := doc.SetRoot(root); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ParseDocument parses the XML document xml like ParseXml. It fails if
+// the document has no or more than one root element, or text other than
+// white space outside of it.
+func ParseDocument(xml string, opts ...Option) (*Document, error) {
+	tree, err := ParseXml(xml, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(tree)
+}
+
+// ParseDocumentReader parses the XML document read from r like
+// ParseReader, with the checks of ParseDocument.
+func ParseDocumentReader(r io.Reader, opts ...Option) (*Document, error) {
+	tree, err := ParseReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(tree)
+}
+
+// newDocument takes the children of the XN_Dummy node tree apart.
+func newDocument(tree *XmlNode) (*Document, error) {
+	doc := &Document{}
+	for _, n := range tree.Children() {
+		n.parent = nil
+		switch n.ntype {
+		case XN_Head:
+			doc.Decl = tree.Decl()
+		case XN_Doctype:
+			doc.Doctype = n.dtd
+			doc.dtPos = len(doc.Prolog)
+		case XN_Tag:
+			if doc.root != nil {
+				return nil, ErrManyRoots
+			}
+			doc.root = n
+		case XN_Text, XN_CData:
+			if n.ntype == XN_CData || strings.TrimFunc(n.name, isSpace) != "" {
+				return nil, ErrOutside
+			}
+			fallthrough
+		default:
+			if doc.root == nil {
+				doc.Prolog = append(doc.Prolog, n)
+			} else {
+				doc.Epilog = append(doc.Epilog, n)
+			}
+		}
+	}
+	if doc.root == nil {
+		return nil, ErrNoRoot
+	}
+	tree.sube = nil
+	return doc, nil
+}
+
+// Root returns the root element of the document.
+func (doc *Document) Root() *XmlNode {
+	return doc.root
+}
+
+// SetRoot makes the element root the root element of the document. It
+// is taken out of the tree it is in.
+func (doc *Document) SetRoot(root *XmlNode) error {
+	if root == nil || root.ntype != XN_Tag {
+		return ErrHierarchy
+	}
+	root.detach()
+	doc.root = root
+	return nil
+}
+
+// ShowDocument prints the document doc to w like ShowXml, to os.Stderr
+// if w is nil.
+func ShowDocument(doc *Document, w io.Writer) {
+	for _, n := range doc.nodes() {
+		ShowXml(n, w, 0)
+	}
+}
+
+// nodes returns the top level nodes of the document in document order.
+func (doc *Document) nodes() []*XmlNode {
+	var nodes []*XmlNode
+	if doc.Decl != nil {
+		nodes = append(nodes, newHead(doc.Decl))
+	}
+	dtPos := doc.dtPos
+	if dtPos > len(doc.Prolog) {
+		dtPos = len(doc.Prolog)
+	}
+	nodes = append(nodes, doc.Prolog[:dtPos]...)
+	if dt := doc.Doctype; dt != nil {
+		nodes = append(nodes, &XmlNode{ntype: XN_Doctype, name: dt.Name, value: dt.raw(), dtd: dt})
+	}
+	nodes = append(nodes, doc.Prolog[dtPos:]...)
+	if doc.root != nil {
+		nodes = append(nodes, doc.root)
+	}
+	return append(nodes, doc.Epilog...)
+}
@@ -0,0 +1,542 @@
+package xmlparser
+
+import (
+	"io"
+	"strings"
+)
+
+// Doctype is a parsed <!DOCTYPE ...> declaration.
+type Doctype struct {
+	Name      string // name of the root element
+	PublicID  string // "" if not declared
+	SystemID  string // "" if not declared
+	Subset    string // internal subset between '[' and ']', as written
+	Elements  []*ElementDecl
+	Attlists  []*AttlistDecl
+	Entities  []*EntityDecl
+	Notations []*NotationDecl
+}
+
+// ElementDecl is an <!ELEMENT ...> declaration.
+type ElementDecl struct {
+	Name    string
+	Content string // EMPTY, ANY or the content model, e.g. "(title, author+)"
+}
+
+// AttlistDecl is an <!ATTLIST ...> declaration.
+type AttlistDecl struct {
+	Element string
+	Attrs   []*AttrDecl
+}
+
+// AttrDecl is one attribute definition of an <!ATTLIST ...> declaration.
+type AttrDecl struct {
+	Name    string
+	Type    string // CDATA, ID, NMTOKENS, ..., or an enumeration like "(a|b)"
+	Default string // #REQUIRED, #IMPLIED, #FIXED or ""
+	Value   string // default value, if any
+}
+
+// EntityDecl is an <!ENTITY ...> declaration.
+type EntityDecl struct {
+	Name      string
+	Parameter bool   // a parameter entity, <!ENTITY % name ...>
+	Value     string // replacement text of an internal entity
+	PublicID  string
+	SystemID  string // non-empty for an external entity
+	NData     string // notation of an unparsed entity
+}
+
+// NotationDecl is a <!NOTATION ...> declaration.
+type NotationDecl struct {
+	Name     string
+	PublicID string
+	SystemID string
+}
+
+// Entity returns the declaration of the general entity name, or nil.
+func (dt *Doctype) Entity(name string) *EntityDecl {
+	for _, e := range dt.Entities {
+		if !e.Parameter && e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Attr returns the declaration of the attribute name of element elem,
+// or nil.
+func (dt *Doctype) Attr(elem, name string) *AttrDecl {
+	for _, al := range dt.Attlists {
+		if al.Element != elem {
+			continue
+		}
+		for _, a := range al.Attrs {
+			if a.Name == name {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+// raw returns the text of the declaration between "<!DOCTYPE" and
+// '>', as a XN_Doctype node keeps it.
+func (dt *Doctype) raw() string {
+	var b strings.Builder
+	b.WriteString(" " + dt.Name)
+	switch {
+	case dt.PublicID != "":
+		b.WriteString(" PUBLIC " + quoteLiteral(dt.PublicID))
+		if dt.SystemID != "" {
+			b.WriteString(" " + quoteLiteral(dt.SystemID))
+		}
+	case dt.SystemID != "":
+		b.WriteString(" SYSTEM " + quoteLiteral(dt.SystemID))
+	}
+	if dt.Subset != "" {
+		b.WriteString(" [" + dt.Subset + "]")
+	}
+	return b.String()
+}
+
+// quoteLiteral quotes s with double quotes, or with single quotes if s
+// contains a double quote.
+func quoteLiteral(s string) string {
+	if strings.IndexByte(s, '"') >= 0 {
+		return "'" + s + "'"
+	}
+	return `"` + s + `"`
+}
+
+// readDoctype reads a DOCTYPE declaration after its "<!D" up to and
+// including the closing '>', and returns the text in between
+// "<!DOCTYPE" and '>'.
+func (xr *xmlReader) readDoctype() (string, error) {
+	for i := 1; i < len("DOCTYPE"); i++ {
+		if c, _, err := xr.ReadRune(); err != nil && err != io.EOF {
+			return "", err
+		} else if err != nil || c != rune("DOCTYPE"[i]) {
+			return "", xr.syntaxError("dt1")
+		}
+	}
+
+	var val strings.Builder
+	var quote rune  // quote of the literal being read, or 0
+	var subset bool // inside the internal subset
+	var skip string // end of the comment or PI being skipped
+	for {
+		c, _, err := xr.ReadRune()
+		if err == io.EOF {
+			return "", xr.syntaxError("dt2")
+		} else if err != nil {
+			return "", err
+		}
+		val.WriteRune(c)
+
+		switch {
+		case skip != "":
+			if strings.HasSuffix(val.String(), skip) {
+				skip = ""
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case subset && c == '-' && strings.HasSuffix(val.String(), "<!--"):
+			skip = "-->"
+		case subset && c == '?' && strings.HasSuffix(val.String(), "<?"):
+			skip = "?>"
+		case c == '[' && !subset:
+			subset = true
+		case c == ']' && subset:
+			subset = false
+		case c == '>' && !subset:
+			s := val.String()
+			return s[:len(s)-1], nil
+		}
+	}
+}
+
+// doctype parses the DOCTYPE declaration raw, which starts at input
+// position start, and keeps it for entity expansion.
+func (xr *xmlReader) doctype(raw string, start Pos) error {
+	dt, err := parseDoctype(raw, start)
+	if err == nil {
+		xr.dtd = dt
+	}
+	return err
+}
+
+// dtdParser parses the text of a DOCTYPE declaration.
+type dtdParser struct {
+	s     string
+	i     int
+	start Pos // input position of s[0]
+}
+
+func (p *dtdParser) error(expected string) error {
+	bol := strings.LastIndexByte(p.s[:p.i], '\n') + 1
+	found := p.s[p.i:]
+	if n := strings.IndexAny(found, " \t\r\n"); n > 0 {
+		found = found[:n]
+	}
+	return &SyntaxError{
+		Pos:      p.start.advance(p.s[:p.i]),
+		State:    "dtd",
+		Found:    found,
+		Expected: expected,
+		Excerpt:  caretExcerpt(p.s[bol:p.i], p.s[p.i:]),
+	}
+}
+
+func (p *dtdParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+// space skips white space and reports whether there was any.
+func (p *dtdParser) space() bool {
+	i := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+	return p.i > i
+}
+
+// lit consumes the literal text s if it comes next.
+func (p *dtdParser) lit(s string) bool {
+	if strings.HasPrefix(p.s[p.i:], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+// name reads a name, which ends at white space or a delimiter.
+func (p *dtdParser) name() (string, error) {
+	i := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n>[]()|,\"'%;", p.s[p.i]) < 0 {
+		p.i++
+	}
+	if p.i == i {
+		return "", p.error("a name")
+	}
+	return p.s[i:p.i], nil
+}
+
+// quoted reads a quoted literal and returns its contents.
+func (p *dtdParser) quoted() (string, error) {
+	if p.eof() || p.s[p.i] != '"' && p.s[p.i] != '\'' {
+		return "", p.error("a quoted literal")
+	}
+	end := strings.IndexByte(p.s[p.i+1:], p.s[p.i])
+	if end < 0 {
+		return "", p.error("closing quote")
+	}
+	v := p.s[p.i+1 : p.i+1+end]
+	p.i += end + 2
+	return v, nil
+}
+
+// externalID reads SYSTEM "sys" or PUBLIC "pub" "sys". With pubOnly,
+// the system literal may be left out after PUBLIC, as in notations.
+func (p *dtdParser) externalID(pubOnly bool) (pub, sys string, err error) {
+	if p.lit("SYSTEM") {
+		p.space()
+		sys, err = p.quoted()
+		return
+	}
+	if !p.lit("PUBLIC") {
+		return "", "", p.error("SYSTEM or PUBLIC")
+	}
+	p.space()
+	if pub, err = p.quoted(); err != nil {
+		return
+	}
+	i := p.i
+	p.space()
+	if pubOnly && (p.eof() || p.s[p.i] == '>') {
+		p.i = i
+		return
+	}
+	sys, err = p.quoted()
+	return
+}
+
+// group reads a parenthesized group, such as a content model or an
+// enumeration, and returns it as written.
+func (p *dtdParser) group() (string, error) {
+	i := p.i
+	depth := 0
+	for ; p.i < len(p.s); p.i++ {
+		switch p.s[p.i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '>':
+			return "", p.error("')'")
+		}
+		if depth == 0 {
+			p.i++
+			for p.i < len(p.s) && strings.IndexByte("?*+", p.s[p.i]) >= 0 {
+				p.i++
+			}
+			return p.s[i:p.i], nil
+		}
+	}
+	return "", p.error("')'")
+}
+
+// end consumes the '>' closing a markup declaration.
+func (p *dtdParser) end() error {
+	p.space()
+	if !p.lit(">") {
+		return p.error("'>'")
+	}
+	return nil
+}
+
+// parseDoctype parses the text between "<!DOCTYPE" and '>'.
+// start is the input position of raw[0].
+func parseDoctype(raw string, start Pos) (*Doctype, error) {
+	p := &dtdParser{s: raw, start: start}
+	dt := &Doctype{}
+	var err error
+
+	if !p.space() {
+		return nil, p.error("white space")
+	}
+	if dt.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	p.space()
+	if !p.eof() && p.s[p.i] != '[' {
+		if dt.PublicID, dt.SystemID, err = p.externalID(false); err != nil {
+			return nil, err
+		}
+		p.space()
+	}
+	if p.lit("[") {
+		i := p.i
+		if err = p.subset(dt); err != nil {
+			return nil, err
+		}
+		dt.Subset = raw[i : p.i-1]
+		p.space()
+	}
+	if !p.eof() {
+		return nil, p.error("'[' or '>'")
+	}
+	return dt, nil
+}
+
+// subset parses the markup declarations of the internal subset up to
+// and including the closing ']'.
+func (p *dtdParser) subset(dt *Doctype) error {
+	for {
+		p.space()
+		switch {
+		case p.eof():
+			return p.error("']'")
+		case p.lit("]"):
+			return nil
+		case p.lit("<!--"):
+			end := strings.Index(p.s[p.i:], "-->")
+			if end < 0 {
+				return p.error(`"-->"`)
+			}
+			p.i += end + 3
+		case p.lit("<?"):
+			end := strings.Index(p.s[p.i:], "?>")
+			if end < 0 {
+				return p.error(`"?>"`)
+			}
+			p.i += end + 2
+		case p.lit("%"):
+			// parameter entity reference, not expanded
+			if _, err := p.name(); err != nil {
+				return err
+			}
+			if !p.lit(";") {
+				return p.error("';'")
+			}
+		case p.lit("<!ELEMENT"):
+			if err := p.elementDecl(dt); err != nil {
+				return err
+			}
+		case p.lit("<!ATTLIST"):
+			if err := p.attlistDecl(dt); err != nil {
+				return err
+			}
+		case p.lit("<!ENTITY"):
+			if err := p.entityDecl(dt); err != nil {
+				return err
+			}
+		case p.lit("<!NOTATION"):
+			if err := p.notationDecl(dt); err != nil {
+				return err
+			}
+		default:
+			return p.error("a markup declaration")
+		}
+	}
+}
+
+func (p *dtdParser) elementDecl(dt *Doctype) (err error) {
+	el := &ElementDecl{}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if el.Name, err = p.name(); err != nil {
+		return
+	}
+	if !p.space() {
+		return p.error("white space")
+	}
+	switch {
+	case p.lit("EMPTY"):
+		el.Content = "EMPTY"
+	case p.lit("ANY"):
+		el.Content = "ANY"
+	case !p.eof() && p.s[p.i] == '(':
+		if el.Content, err = p.group(); err != nil {
+			return
+		}
+	default:
+		return p.error("EMPTY, ANY or a content model")
+	}
+	if err = p.end(); err != nil {
+		return
+	}
+	dt.Elements = append(dt.Elements, el)
+	return nil
+}
+
+func (p *dtdParser) attlistDecl(dt *Doctype) (err error) {
+	al := &AttlistDecl{}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if al.Element, err = p.name(); err != nil {
+		return
+	}
+	for {
+		p.space()
+		if p.lit(">") {
+			break
+		}
+
+		ad := &AttrDecl{}
+		if ad.Name, err = p.name(); err != nil {
+			return
+		}
+		if !p.space() {
+			return p.error("white space")
+		}
+		if !p.eof() && p.s[p.i] == '(' {
+			ad.Type, err = p.group()
+		} else {
+			ad.Type, err = p.name()
+			if err == nil && ad.Type == "NOTATION" {
+				p.space()
+				var g string
+				g, err = p.group()
+				ad.Type += " " + g
+			}
+		}
+		if err != nil {
+			return
+		}
+		switch ad.Type {
+		case "CDATA", "ID", "IDREF", "IDREFS", "ENTITY", "ENTITIES", "NMTOKEN", "NMTOKENS":
+		default:
+			if !strings.HasPrefix(ad.Type, "(") && !strings.HasPrefix(ad.Type, "NOTATION (") {
+				return p.error("an attribute type")
+			}
+		}
+		if !p.space() {
+			return p.error("white space")
+		}
+
+		switch {
+		case p.lit("#REQUIRED"):
+			ad.Default = "#REQUIRED"
+		case p.lit("#IMPLIED"):
+			ad.Default = "#IMPLIED"
+		case p.lit("#FIXED"):
+			ad.Default = "#FIXED"
+			p.space()
+			fallthrough
+		default:
+			if ad.Value, err = p.quoted(); err != nil {
+				return
+			}
+		}
+		al.Attrs = append(al.Attrs, ad)
+	}
+	dt.Attlists = append(dt.Attlists, al)
+	return nil
+}
+
+func (p *dtdParser) entityDecl(dt *Doctype) (err error) {
+	ent := &EntityDecl{}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if p.lit("%") {
+		ent.Parameter = true
+		if !p.space() {
+			return p.error("white space")
+		}
+	}
+	if ent.Name, err = p.name(); err != nil {
+		return
+	}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if !p.eof() && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+		if ent.Value, err = p.quoted(); err != nil {
+			return
+		}
+	} else {
+		if ent.PublicID, ent.SystemID, err = p.externalID(false); err != nil {
+			return
+		}
+		if p.space() && !ent.Parameter && p.lit("NDATA") {
+			p.space()
+			if ent.NData, err = p.name(); err != nil {
+				return
+			}
+		}
+	}
+	if err = p.end(); err != nil {
+		return
+	}
+	dt.Entities = append(dt.Entities, ent)
+	return nil
+}
+
+func (p *dtdParser) notationDecl(dt *Doctype) (err error) {
+	nt := &NotationDecl{}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if nt.Name, err = p.name(); err != nil {
+		return
+	}
+	if !p.space() {
+		return p.error("white space")
+	}
+	if nt.PublicID, nt.SystemID, err = p.externalID(true); err != nil {
+		return
+	}
+	if err = p.end(); err != nil {
+		return
+	}
+	dt.Notations = append(dt.Notations, nt)
+	return nil
+}
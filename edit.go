@@ -0,0 +1,179 @@
+package xmlparser
+
+import (
+	"errors"
+	"strings"
+)
+
+// errors returned by the tree editing methods
+var (
+	ErrNotChild  = errors.New("xmlparser: node is not a child of the parent")
+	ErrHierarchy = errors.New("xmlparser: node cannot be inserted here")
+)
+
+// NewElement returns a new element without properties or children.
+// The namespace of its name is not resolved, only split into prefix and
+// local name.
+func NewElement(name string) *XmlNode {
+	return &XmlNode{ntype: XN_Tag, name: name, qname: splitName(name)}
+}
+
+// NewText returns a new text node with the content s.
+func NewText(s string) *XmlNode {
+	return &XmlNode{ntype: XN_Text, name: s}
+}
+
+// NewComment returns a new comment node with the content s.
+func NewComment(s string) *XmlNode {
+	return &XmlNode{ntype: XN_Comment, name: s}
+}
+
+func splitName(name string) QName {
+	if prefix, local, ok := strings.Cut(name, ":"); ok {
+		return QName{Prefix: prefix, Local: local}
+	}
+	return QName{Local: name}
+}
+
+// SetAttr sets the property name of the node to value, replacing the
+// value of an existing property and adding a new one after the others.
+func (node *XmlNode) SetAttr(name, value string) {
+	for _, p := range node.prop {
+		if p.name == name {
+			p.value = value
+			return
+		}
+	}
+	node.addProp(name, value)
+	node.prop[len(node.prop)-1].qname = splitName(name)
+}
+
+// RemoveAttr removes the property name of the node and reports whether
+// the node had it.
+func (node *XmlNode) RemoveAttr(name string) bool {
+	for i, p := range node.prop {
+		if p.name == name {
+			node.prop = append(node.prop[:i], node.prop[i+1:]...)
+			p.parent = nil
+			return true
+		}
+	}
+	return false
+}
+
+// AppendChild adds child after the last child of the node. A child
+// which is in a tree already is moved.
+func (node *XmlNode) AppendChild(child *XmlNode) error {
+	return node.InsertBefore(child, nil)
+}
+
+// InsertBefore adds child before the child ref of the node, or after the
+// last child if ref is nil. A child which is in a tree already is moved.
+func (node *XmlNode) InsertBefore(child, ref *XmlNode) error {
+	if err := node.canAdopt(child); err != nil {
+		return err
+	}
+	if ref != nil && node.index(ref) < 0 {
+		return ErrNotChild
+	}
+	if ref == child {
+		return nil
+	}
+	child.detach()
+
+	i := len(node.sube)
+	if ref != nil {
+		i = node.index(ref)
+	}
+	node.sube = append(node.sube, nil)
+	copy(node.sube[i+1:], node.sube[i:])
+	node.sube[i] = child
+	child.parent = node
+	return nil
+}
+
+// RemoveChild removes child from the children of the node.
+func (node *XmlNode) RemoveChild(child *XmlNode) error {
+	if child == nil || node.index(child) < 0 {
+		return ErrNotChild
+	}
+	child.detach()
+	return nil
+}
+
+// ReplaceWith puts n in the place of the child old of the node, which
+// is removed.
+func (node *XmlNode) ReplaceWith(old, n *XmlNode) error {
+	if old == nil || node.index(old) < 0 {
+		return ErrNotChild
+	}
+	if n == old {
+		return nil
+	}
+	if err := node.InsertBefore(n, old); err != nil {
+		return err
+	}
+	old.detach()
+	return nil
+}
+
+// Clone returns a deep copy of the node which is not in any tree. The
+// parsed DOCTYPE of a XN_Doctype node is shared with the copy.
+func (node *XmlNode) Clone() *XmlNode {
+	n := *node
+	n.parent = nil
+	n.prop = nil
+	n.sube = nil
+	for _, p := range node.prop {
+		p = p.Clone()
+		p.parent = &n
+		n.prop = append(n.prop, p)
+	}
+	for _, c := range node.sube {
+		n.add(c.Clone())
+	}
+	return &n
+}
+
+// canAdopt checks that child may be a child of the node.
+func (node *XmlNode) canAdopt(child *XmlNode) error {
+	if child == nil || node.ntype != XN_Tag && node.ntype != XN_Dummy {
+		return ErrHierarchy
+	}
+	switch child.ntype {
+	case XN_Dummy, XN_Prop, XN_Property:
+		return ErrHierarchy
+	case XN_Head, XN_Doctype:
+		if node.ntype != XN_Dummy {
+			return ErrHierarchy
+		}
+	}
+	for n := node; n != nil; n = n.parent {
+		if n == child {
+			return ErrHierarchy
+		}
+	}
+	return nil
+}
+
+// index returns the position of child among the children of the node.
+func (node *XmlNode) index(child *XmlNode) int {
+	for i, n := range node.sube {
+		if n == child {
+			return i
+		}
+	}
+	return -1
+}
+
+// detach removes the node from the children of its parent.
+func (node *XmlNode) detach() {
+	parent := node.parent
+	if parent == nil {
+		return
+	}
+	if i := parent.index(node); i >= 0 {
+		parent.sube = append(parent.sube[:i], parent.sube[i+1:]...)
+	}
+	node.parent = nil
+}
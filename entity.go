@@ -0,0 +1,310 @@
+package xmlparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// predefined entities of XML 1.0
+var xmlEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// isXmlChar reports whether c matches the Char production of XML 1.0.
+func isXmlChar(c rune) bool {
+	return c == 0x09 || c == 0x0A || c == 0x0D ||
+		(c >= 0x20 && c <= 0xD7FF) ||
+		(c >= 0xE000 && c <= 0xFFFD) ||
+		(c >= 0x10000 && c <= 0x10FFFF)
+}
+
+// isNameStartChar reports whether c matches the NameStartChar
+// production of XML 1.0.
+func isNameStartChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' ||
+		(c >= 0xC0 && c <= 0xD6) ||
+		(c >= 0xD8 && c <= 0xF6) ||
+		(c >= 0xF8 && c <= 0x2FF) ||
+		(c >= 0x370 && c <= 0x37D) ||
+		(c >= 0x37F && c <= 0x1FFF) ||
+		(c >= 0x200C && c <= 0x200D) ||
+		(c >= 0x2070 && c <= 0x218F) ||
+		(c >= 0x2C00 && c <= 0x2FEF) ||
+		(c >= 0x3001 && c <= 0xD7FF) ||
+		(c >= 0xF900 && c <= 0xFDCF) ||
+		(c >= 0xFDF0 && c <= 0xFFFD) ||
+		(c >= 0x10000 && c <= 0xEFFFF)
+}
+
+// isNameChar reports whether c matches the NameChar production of XML 1.0.
+func isNameChar(c rune) bool {
+	return isNameStartChar(c) || c >= '0' && c <= '9' || c == '-' || c == '.' ||
+		c == 0xB7 ||
+		(c >= 0x300 && c <= 0x36F) ||
+		(c >= 0x203F && c <= 0x2040)
+}
+
+// isSpace reports whether c matches the S production of XML 1.0.
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// unescape replaces the entity and character references in s.
+// start is the input position of s[0] and is used to position errors.
+// Besides the predefined entities, the internal general entities
+// declared in the DOCTYPE are expanded, within the limits of the options.
+// Replacement text containing markup is rejected.
+func (xr *xmlReader) unescape(s string, start Pos) (string, error) {
+	if strings.IndexByte(s, '&') < 0 {
+		return s, nil
+	}
+
+	x := expansion{xr: xr}
+	x.b.Grow(len(s))
+	if err := x.expand(s, start, 0); err != nil {
+		return "", err
+	}
+	return x.b.String(), nil
+}
+
+// propValue decodes the value s of the property key of element tag,
+// which starts at input position start, and normalizes it as section
+// 3.3.3 of the XML specification describes.
+func (xr *xmlReader) propValue(s string, start Pos, tag, key string) (string, error) {
+	if !xr.opt.decode && !xr.opt.normalize {
+		return s, nil
+	}
+	if !xr.opt.decode || strings.IndexByte(s, '&') < 0 {
+		if xr.opt.normalize {
+			s = spaceReplacer.Replace(s)
+		}
+	} else {
+		x := expansion{xr: xr, prop: xr.opt.normalize}
+		x.b.Grow(len(s))
+		if err := x.expand(s, start, 0); err != nil {
+			return "", err
+		}
+		s = x.b.String()
+	}
+
+	if xr.opt.normalize && xr.dtd != nil {
+		if a := xr.dtd.Attr(tag, key); a != nil && a.Type != "CDATA" {
+			s = collapseSpaces(s)
+		}
+	}
+	return s, nil
+}
+
+// spaceReplacer replaces the whitespace characters of a property value.
+var spaceReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// collapseSpaces trims the spaces around s and replaces runs of spaces
+// in s with one space.
+func collapseSpaces(s string) string {
+	fields := strings.Split(s, " ")
+	n := 0
+	for _, f := range fields {
+		if f != "" {
+			fields[n] = f
+			n++
+		}
+	}
+	return strings.Join(fields[:n], " ")
+}
+
+// expansion is the state of decoding one text or property value.
+type expansion struct {
+	xr   *xmlReader
+	prop bool // normalize the whitespace of a property value
+	b    strings.Builder
+	open []string // names of the entities being expanded, outermost first
+
+	// input text and its position, and the offset in it of the
+	// outermost reference being expanded, to position errors in
+	// replacement text
+	in    string
+	start Pos
+	ref   int
+}
+
+// at returns the input position of the outermost entity reference
+// being expanded. It is only computed for errors, so that expanding
+// many references stays linear.
+func (x *expansion) at() Pos {
+	return x.start.advance(x.in[:x.ref])
+}
+
+// expand writes s to x.b with its references replaced. At depth 0, s
+// is input text starting at start; deeper, s is the replacement text
+// of the innermost open entity and errors are positioned at x.at().
+func (x *expansion) expand(s string, start Pos, depth int) error {
+	i := 0 // s[:i] has been decoded
+	for {
+		amp := strings.IndexByte(s[i:], '&')
+		if amp < 0 {
+			break
+		}
+		amp += i
+		if err := x.text(s[i:amp], depth); err != nil {
+			return err
+		}
+		semi := strings.IndexByte(s[amp:], ';')
+		if semi < 0 {
+			return x.refError(s, amp, len(s), start, depth, "';'")
+		}
+		semi += amp
+		ref := s[amp+1 : semi]
+		if strings.HasPrefix(ref, "#") {
+			c, err := charRef(ref[1:])
+			if err != nil {
+				return x.refError(s, amp, semi+1, start, depth, "a valid character reference")
+			}
+			if err := x.write(string(c), depth); err != nil {
+				return err
+			}
+		} else if v, ok := xmlEntities[ref]; ok {
+			if err := x.write(v, depth); err != nil {
+				return err
+			}
+		} else if err := x.entity(s, amp, semi, start, depth); err != nil {
+			return err
+		}
+		i = semi + 1
+	}
+	return x.text(s[i:], depth)
+}
+
+// text writes the text s outside references. Markup in replacement
+// text is not parsed, so a '<' there is an error: in a property value
+// it breaks the "No < in Attribute Values" constraint, and in content
+// it would start an element the tree cannot get.
+func (x *expansion) text(s string, depth int) error {
+	if depth > 0 && strings.IndexByte(s, '<') >= 0 {
+		msg := "markup in replacement text of &" + x.open[len(x.open)-1] + "; is not supported"
+		if x.prop {
+			msg = "'<' in replacement text of &" + x.open[len(x.open)-1] + "; in a property value"
+		}
+		return &SyntaxError{Pos: x.at(), State: "ref", Found: "&" + x.open[0] + ";", Msg: msg}
+	}
+	return x.write(x.literal(s), depth)
+}
+
+// literal returns the text s written as is, outside references.
+func (x *expansion) literal(s string) string {
+	if x.prop {
+		return spaceReplacer.Replace(s)
+	}
+	return s
+}
+
+// entity expands the general entity referenced by s[amp:semi+1].
+func (x *expansion) entity(s string, amp, semi int, start Pos, depth int) error {
+	name := s[amp+1 : semi]
+	var e *EntityDecl
+	if x.xr.dtd != nil {
+		e = x.xr.dtd.Entity(name)
+	}
+	switch {
+	case e == nil:
+		return x.refError(s, amp, semi+1, start, depth, "a declared entity")
+	case e.NData != "":
+		return x.limitError(s, amp, semi, start, depth, "reference to unparsed entity")
+	case e.SystemID != "":
+		return x.limitError(s, amp, semi, start, depth, "external entity is not supported")
+	}
+	for _, open := range x.open {
+		if open == name {
+			return x.limitError(s, amp, semi, start, depth, "recursive entity reference")
+		}
+	}
+	if depth >= x.xr.opt.entityDepth {
+		return x.limitError(s, amp, semi, start, depth,
+			fmt.Sprintf("entity nesting exceeds %d levels", x.xr.opt.entityDepth))
+	}
+
+	if depth == 0 {
+		x.in, x.start, x.ref = s, start, amp
+	}
+	x.open = append(x.open, name)
+	if err := x.expand(e.Value, start, depth+1); err != nil {
+		return err
+	}
+	x.open = x.open[:len(x.open)-1]
+	return nil
+}
+
+// write appends text to the result. Text of a replacement counts
+// towards the expansion limit of the document.
+func (x *expansion) write(text string, depth int) error {
+	if depth > 0 {
+		x.xr.expanded += int64(len(text))
+		if max := x.xr.opt.entitySize; x.xr.expanded > max {
+			return &SyntaxError{
+				Pos:   x.at(),
+				State: "ref",
+				Found: "&" + x.open[0] + ";",
+				Msg:   fmt.Sprintf("entity expansion exceeds %d bytes", max),
+			}
+		}
+	}
+	x.b.WriteString(text)
+	return nil
+}
+
+// refError returns the error for the bad reference s[from:to],
+// where s starts at input position start.
+func (x *expansion) refError(s string, from, to int, start Pos, depth int, expected string) *SyntaxError {
+	if depth > 0 {
+		return &SyntaxError{
+			Pos:      x.at(),
+			State:    "ref",
+			Found:    s[from:to],
+			Expected: expected,
+			Msg:      "in replacement text of &" + x.open[len(x.open)-1] + ";",
+		}
+	}
+	bol := strings.LastIndexByte(s[:from], '\n') + 1
+	return &SyntaxError{
+		Pos:      start.advance(s[:from]),
+		State:    "ref",
+		Found:    s[from:to],
+		Expected: expected,
+		Excerpt:  caretExcerpt(s[bol:from], s[from:]),
+	}
+}
+
+// limitError returns the error msg for the entity reference s[amp:semi+1].
+func (x *expansion) limitError(s string, amp, semi int, start Pos, depth int, msg string) *SyntaxError {
+	err := x.refError(s, amp, semi+1, start, depth, "")
+	if err.Msg != "" {
+		msg += " " + err.Msg
+	}
+	err.Msg = msg
+	return err
+}
+
+// charRef decodes the digits of a "&#...;" or "&#x...;" reference.
+func charRef(ref string) (rune, error) {
+	base := 10
+	if strings.HasPrefix(ref, "x") {
+		base = 16
+		ref = ref[1:]
+	}
+	if ref == "" || ref[0] == '+' || ref[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	n, err := strconv.ParseUint(ref, base, 32)
+	if err != nil {
+		return 0, err
+	}
+	if c := rune(n); n <= utf8.MaxRune && isXmlChar(c) {
+		return c, nil
+	}
+	return 0, strconv.ErrRange
+}
@@ -0,0 +1,106 @@
+package xmlparser
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError reports malformed XML input.
+// Use errors.As to get at the details of an error returned by a scanner
+// or by ParseXml.
+type SyntaxError struct {
+	Pos      Pos    // position of the offending input
+	State    string // scanner state, "ref" for a bad reference, "" for the tree builder
+	Found    string // offending input, "" at the end of input
+	Expected string // what was expected instead, may be empty
+	Msg      string // description of the error, may be empty
+	Excerpt  string // source line up to the offending input and a caret under it
+}
+
+func (e *SyntaxError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "syntax error: at %v (offset %v)", e.Pos, e.Pos.Offset)
+	if e.Msg != "" {
+		fmt.Fprintf(&b, ", %v", e.Msg)
+	}
+	if e.Found == "" {
+		b.WriteString(", unexpected EOF")
+	} else {
+		fmt.Fprintf(&b, ", found %q", e.Found)
+	}
+	if e.Expected != "" {
+		fmt.Fprintf(&b, ", expected %v", e.Expected)
+	}
+	return b.String()
+}
+
+// what the scanner states accept, for error messages
+var stateExpects = map[string]string{
+	"start": "'<'",
+	"lt":    `a name, '/', '!' or '?'`,
+	"ot1":   `a name character, whitespace, '>' or "/>"`,
+	"pt1":   `a property name, '=', '>' or "/>"`,
+	"ct1":   "a name character, whitespace or '>'",
+	"h1":    `"?>"`,
+	"h2":    `"?>"`,
+	"h3":    "'<'",
+	"ot2":   "'<'",
+	"ct2":   "'<'",
+	"ct3":   "'>'",
+	"pe":    "'='",
+	"pt2":   "a property value",
+	"pt4":   "'>'",
+	"pq":    "closing quote",
+	"pt3":   `whitespace, '>' or "/>"`,
+	"et":    "'>'",
+	"cm1":   `"--", "[CDATA[" or "DOCTYPE"`,
+	"cm2":   "'-'",
+	"cm3":   `"-->"`,
+	"cm4":   `"-->"`,
+	"cm5":   `'>' after "--"`,
+	"cm6":   "'<'",
+	"cd1":   `"[CDATA["`,
+	"dt1":   `"<!DOCTYPE"`,
+	"dt2":   "'>'",
+	"cd2":   `"]]>"`,
+	"cd3":   `"]]>"`,
+	"cd4":   `"]]>"`,
+}
+
+// maximum length of the source text on either side of an error
+const excerptLen = 40
+
+// caretExcerpt returns before+after as one line and a second line
+// with a caret under the first rune of after.
+func caretExcerpt(before, after string) string {
+	if len(before) > excerptLen {
+		before = before[len(before)-excerptLen:]
+		for len(before) > 0 && !utf8.RuneStart(before[0]) {
+			before = before[1:]
+		}
+	}
+	if i := strings.IndexAny(after, "\r\n"); i >= 0 {
+		after = after[:i]
+	}
+	if len(after) > excerptLen {
+		after = after[:excerptLen]
+		for len(after) > 0 && !utf8.ValidString(after) {
+			after = after[:len(after)-1]
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(before)
+	b.WriteString(after)
+	b.WriteByte('\n')
+	for _, c := range before {
+		if c == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	return b.String()
+}
@@ -2,27 +2,48 @@ package xmlparser
 
 import (
 	"bytes"
-	"fmt"
+	"io"
+	"strings"
 )
 
-func scanXml2(xml string) XmlScanner {
-	xmlr := bytes.NewReader([]byte(xml))
+func scanXml2(xml string, opts ...Option) XmlScanner {
+	opt := newOptions(opts)
+	xmlr := newXmlReader(strings.NewReader(xml), opt)
 
 	var val bytes.Buffer
 	nextToken := XmlToken{}
 	fgStopped := false
 	fgStarted := false
 	var errAction error
-	var syntaxErrOff int64
-	var hasHeader bool
+	var errState string
+	var tkStart Pos
+	var atStart bool // the markup being scanned is the first of the document
+	var quote rune
+	var tagName string
+	var propKey string // name of the property being scanned
+	var depth int      // number of open elements
 	var nextFn func()
 	var fn_start, fn_return, fn_err, fn_serr, fn_lt, fn_h1,
-		fn_h2, fn_h3, fn_ct1, fn_ct2, fn_ot1, fn_ot2, fn_tt,
-		fn_pt1, fn_pt2, fn_cm1, fn_cm2, fn_cm3, fn_cm4,
-		fn_cm5, fn_cm6 func()
+		fn_h2, fn_h3, fn_ct1, fn_ct2, fn_ct3, fn_ot1, fn_ot2, fn_tt,
+		fn_pt1, fn_pe, fn_pt2, fn_pq, fn_pt3, fn_pt4, fn_et, fn_cm1, fn_cm2, fn_cm3, fn_cm4,
+		fn_cm5, fn_cm6, fn_cd1, fn_cd2, fn_cd3, fn_cd4,
+		fn_dt func()
 	var returnToken bool
 	var returnErr bool
 
+	// decode unescapes a text or property value starting at start.
+	decode := func(s string, start Pos) (string, error) {
+		if !opt.decode {
+			return s, nil
+		}
+		return xmlr.unescape(s, start)
+	}
+
+	// decodeValue unescapes and normalizes the value of property propKey.
+	decodeValue := func(s string, start Pos) (string, error) {
+		return xmlr.propValue(s, start, tagName, propKey)
+	}
+
 	fn_start = func() {
 		for {
 			if c, _, err := xmlr.ReadRune(); err != nil {
@@ -30,11 +51,13 @@ func scanXml2(xml string) XmlScanner {
 				nextFn = fn_err
 				break
 			} else if c == '<' {
+				atStart = true
 				nextFn = fn_lt
 				break
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "start"
 				nextFn = fn_serr
 				break
 			}
@@ -52,60 +75,59 @@ func scanXml2(xml string) XmlScanner {
 	}
 
 	fn_serr = func() {
-		syntaxErrOff = xmlr.Size() - int64(xmlr.Len())
-		if syntaxErrOff < xmlr.Size() {
-			tmp := xml[syntaxErrOff:]
-			if len(tmp) > 16 {
-				tmp = tmp[:16]
-			}
-			errAction = fmt.Errorf("syntax error: at %v, before %v",
-				syntaxErrOff, tmp)
-		}
+		errAction = xmlr.syntaxError(errState)
 		nextFn = fn_return
 		fgStopped = true
 	}
 
 	fn_lt = func() {
+		tkStart = xmlr.lastPos()
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "lt"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '?' {
-				if !hasHeader {
-					hasHeader = true
-					nextFn = fn_h1
-					break
-				} else {
-					nextFn = fn_serr
-					break
-				}
+				nextFn = fn_h1
+				break
 			} else if c == '/' {
+				atStart = false
 				nextFn = fn_ct1
 				break
 			} else if c == '!' {
+				atStart = false
 				nextFn = fn_cm1
 				break
-			} else {
+			} else if isNameStartChar(c) {
+				atStart = false
 				val.WriteRune(c)
 				nextFn = fn_ot1
 				break
+			} else {
+				errState = "lt"
+				nextFn = fn_serr
+				break
 			}
 		}
 	}
 
 	fn_h1 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "h1"
+				nextFn = fn_serr // unterminated processing instruction
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '?' {
 				nextFn = fn_h2
 				break
-			} else if c == '>' || c == '<' {
-				nextFn = fn_serr
-				break
 			} else {
 				val.WriteRune(c)
 			}
@@ -114,17 +136,26 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_h2 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "h2"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '>' {
-				nextToken = XmlToken{XML_HEAD, val.String()}
+				nextToken = XmlToken{XML_PI, val.String(), tkStart, xmlr.Pos()}
+				nextToken.ID, errAction = xmlr.procInst(nextToken.Val, tkStart, atStart)
+				atStart = false
 				val.Reset()
 				nextFn = fn_h3
 				returnToken = true
 				break
+			} else if c == '?' {
+				val.WriteRune('?')
 			} else {
+				val.WriteRune('?')
 				val.WriteRune(c)
 				nextFn = fn_h1
 				break
@@ -138,12 +169,30 @@ func scanXml2(xml string) XmlScanner {
 				errAction = err
 				nextFn = fn_err
 				break
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextFn = fn_lt
+				returnToken = true
+				break
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				nextFn = fn_lt
 				break
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					nextFn = fn_tt
+					break
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "h3"
 				nextFn = fn_serr
 				break
 			}
@@ -152,19 +201,56 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_ct1 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ct1"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
-			} else if c == '>' {
-				nextToken = XmlToken{XML_TAG_CLOSE, val.String()}
+			} else if c == '>' && val.Len() > 0 {
+				nextToken = XmlToken{XML_TAG_CLOSE, val.String(), tkStart, xmlr.Pos()}
+				depth--
 				val.Reset()
 				nextFn = fn_ct2
 				returnToken = true
 				break
-			} else {
+			} else if isSpace(c) && val.Len() > 0 {
+				nextFn = fn_ct3
+				break
+			} else if isNameChar(c) && (val.Len() > 0 || isNameStartChar(c)) {
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "ct1"
+				nextFn = fn_serr
+				break
+			}
+		}
+	}
+
+	fn_ct3 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ct3"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '>' {
+				nextToken = XmlToken{XML_TAG_CLOSE, val.String(), tkStart, xmlr.Pos()}
+				depth--
+				val.Reset()
+				nextFn = fn_ct2
+				returnToken = true
+				break
+			} else if !isSpace(c) {
+				errState = "ct3"
+				nextFn = fn_serr
+				break
 			}
 		}
 	}
@@ -175,12 +261,30 @@ func scanXml2(xml string) XmlScanner {
 				errAction = err
 				nextFn = fn_err
 				break
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextFn = fn_lt
+				returnToken = true
+				break
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				nextFn = fn_lt
 				break
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					nextFn = fn_tt
+					break
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "ct2"
 				nextFn = fn_serr
 				break
 			}
@@ -189,25 +293,45 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_ot1 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ot1"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '>' {
-				nextToken = XmlToken{XML_TAG_OPTN, val.String()}
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
 				val.Reset()
 				nextFn = fn_ot2
 				returnToken = true
 				break
-			} else if c == ' ' {
-				nextToken = XmlToken{XML_TAG_OPTN, val.String()}
+			} else if isSpace(c) {
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
 				val.Reset()
 				nextFn = fn_pt1
 				returnToken = true
 				break
-			} else {
+			} else if c == '/' {
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
+				val.Reset()
+				nextFn = fn_et
+				returnToken = true
+				break
+			} else if isNameChar(c) {
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "ot1"
+				nextFn = fn_serr
+				break
 			}
 		}
 	}
@@ -218,14 +342,31 @@ func scanXml2(xml string) XmlScanner {
 				errAction = err
 				nextFn = fn_err
 				break
-			} else if c == '\n' || c == '\r' || c == '\t' {
-				continue
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextFn = fn_lt
+				returnToken = true
+				break
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				nextFn = fn_lt
 				break
-			} else {
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
 				val.WriteRune(c)
-				nextFn = fn_tt
+				if !isSpace(c) {
+					nextFn = fn_tt
+					break
+				}
+			} else if isSpace(c) {
+				continue
+			} else {
+				errState = "ot2"
+				nextFn = fn_serr
 				break
 			}
 		}
@@ -238,7 +379,8 @@ func scanXml2(xml string) XmlScanner {
 				nextFn = fn_err
 				break
 			} else if c == '<' {
-				nextToken = XmlToken{XML_TEXT, val.String()}
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decode(nextToken.Val, tkStart)
 				val.Reset()
 				nextFn = fn_lt
 				returnToken = true
@@ -252,42 +394,137 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_pt1 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt1"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
-			} else if c == '=' {
-				nextToken = XmlToken{XML_PRO_KEY, val.String()}
+			} else if c == '=' && val.Len() > 0 {
+				nextToken = XmlToken{XML_PRO_KEY, val.String(), tkStart, xmlr.lastPos()}
+				propKey = nextToken.Val
 				val.Reset()
 				nextFn = fn_pt2
 				returnToken = true
 				break
-			} else {
+			} else if isSpace(c) && val.Len() == 0 {
+				continue
+			} else if isSpace(c) {
+				nextToken = XmlToken{XML_PRO_KEY, val.String(), tkStart, xmlr.lastPos()}
+				propKey = nextToken.Val
+				val.Reset()
+				nextFn = fn_pe
+				returnToken = true
+				break
+			} else if c == '/' && val.Len() == 0 {
+				nextFn = fn_et
+				break
+			} else if c == '>' && val.Len() == 0 {
+				nextFn = fn_ot2
+				break
+			} else if isNameChar(c) && (val.Len() > 0 || isNameStartChar(c)) {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "pt1"
+				nextFn = fn_serr
+				break
 			}
 		}
 
 	}
 
+	fn_pe = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pe"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '=' {
+				nextFn = fn_pt2
+				break
+			} else if !isSpace(c) {
+				errState = "pe"
+				nextFn = fn_serr
+				break
+			}
+		}
+	}
+
 	fn_pt2 = func() {
+		if val.Len() == 0 {
+			tkStart = xmlr.Pos()
+		}
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt2"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
-			} else if c == ' ' {
-				nextToken = XmlToken{XML_PRO_VAL, val.String()}
+			} else if (c == '"' || c == '\'') && val.Len() == 0 {
+				quote = c
+				nextFn = fn_pq
+				break
+			} else if isSpace(c) && val.Len() == 0 {
+				tkStart = xmlr.Pos()
+				continue
+			} else if isSpace(c) {
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
 				val.Reset()
 				nextFn = fn_pt1
 				returnToken = true
 				break
 			} else if c == '>' {
-				nextToken = XmlToken{XML_PRO_VAL, val.String()}
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
 				val.Reset()
 				nextFn = fn_ot2
 				returnToken = true
 				break
+			} else if c == '/' {
+				nextFn = fn_pt4
+				break
+			} else {
+				val.WriteRune(c)
+				continue
+			}
+		}
+	}
+
+	fn_pq = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pq"
+				nextFn = fn_serr // unterminated quoted value
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '<' {
+				errState = "pq"
+				nextFn = fn_serr // no '<' in property values
+				break
+			} else if c == quote {
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.Pos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart.advance(string(quote)))
+				val.Reset()
+				nextFn = fn_pt3
+				returnToken = true
+				break
 			} else {
 				val.WriteRune(c)
 				continue
@@ -295,16 +532,106 @@ func scanXml2(xml string) XmlScanner {
 		}
 	}
 
+	fn_pt3 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt3"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if isSpace(c) {
+				nextFn = fn_pt1
+				break
+			} else if c == '>' {
+				nextFn = fn_ot2
+				break
+			} else if c == '/' {
+				nextFn = fn_et
+				break
+			} else {
+				errState = "pt3"
+				nextFn = fn_serr
+				break
+			}
+		}
+	}
+
+	fn_pt4 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt4"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '>' {
+				xmlr.UnreadRune()
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.Pos().back()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
+				val.Reset()
+				nextFn = fn_et
+				returnToken = true
+				break
+			} else {
+				val.WriteRune('/')
+				xmlr.UnreadRune()
+				nextFn = fn_pt2
+				break
+			}
+		}
+	}
+
+	fn_et = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "et"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '>' {
+				tkStart = xmlr.lastPos().back() // the '/'
+				nextToken = XmlToken{XML_TAG_CLOSE, tagName, tkStart, xmlr.Pos()}
+				depth--
+				nextFn = fn_ct2
+				returnToken = true
+				break
+			} else {
+				errState = "et"
+				nextFn = fn_serr
+				break
+			}
+		}
+	}
+
 	fn_cm1 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm1"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '-' {
 				nextFn = fn_cm2
 				break
+			} else if c == '[' {
+				nextFn = fn_cd1
+				break
+			} else if c == 'D' {
+				nextFn = fn_dt
+				break
 			} else {
+				errState = "cm1"
 				nextFn = fn_serr
 				break
 			}
@@ -313,7 +640,11 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_cm2 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm2"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
@@ -321,6 +652,7 @@ func scanXml2(xml string) XmlScanner {
 				nextFn = fn_cm3
 				break
 			} else {
+				errState = "cm2"
 				nextFn = fn_serr
 				break
 			}
@@ -329,16 +661,17 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_cm3 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm3"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '-' {
 				nextFn = fn_cm4
 				break
-			} else if c == '<' || c == '>' {
-				nextFn = fn_serr
-				break
 			} else {
 				val.WriteRune(c)
 				continue
@@ -348,7 +681,11 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_cm4 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm4"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
@@ -366,16 +703,27 @@ func scanXml2(xml string) XmlScanner {
 
 	fn_cm5 = func() {
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm5"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
 				errAction = err
 				nextFn = fn_err
 				break
 			} else if c == '>' {
-				nextToken = XmlToken{XML_COMMENT, val.String()}
+				nextToken = XmlToken{XML_COMMENT, val.String(), tkStart, xmlr.Pos()}
 				val.Reset()
 				nextFn = fn_cm6
 				returnToken = true
 				break
+			} else if opt.strict {
+				errState = "cm5"
+				nextFn = fn_serr // "--" inside the comment
+				break
+			} else if c == '-' {
+				val.WriteRune('-')
+				continue
 			} else {
 				val.WriteString("--")
 				val.WriteRune(c)
@@ -391,21 +739,140 @@ func scanXml2(xml string) XmlScanner {
 				errAction = err
 				nextFn = fn_err
 				break
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextFn = fn_lt
+				returnToken = true
+				break
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				nextFn = fn_lt
 				break
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					nextFn = fn_tt
+					break
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
-				//goto S_cm6
+				continue
 			} else {
+				errState = "cm6"
 				nextFn = fn_serr
 				break
 			}
 		}
 	}
 
+	fn_cd1 = func() {
+		for i := 0; i < len(cdataStart); i++ {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd1"
+				nextFn = fn_serr
+				return
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				return
+			} else if c != rune(cdataStart[i]) {
+				errState = "cd1"
+				nextFn = fn_serr
+				return
+			}
+		}
+		nextFn = fn_cd2
+	}
+
+	fn_cd2 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd2"
+				nextFn = fn_serr // unterminated CDATA section
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == ']' {
+				nextFn = fn_cd3
+				break
+			} else {
+				val.WriteRune(c)
+				continue
+			}
+		}
+	}
+
+	fn_cd3 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd3"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == ']' {
+				nextFn = fn_cd4
+				break
+			} else {
+				val.WriteRune(']')
+				val.WriteRune(c)
+				nextFn = fn_cd2
+				break
+			}
+		}
+	}
+
+	fn_cd4 = func() {
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd4"
+				nextFn = fn_serr
+				break
+			} else if err != nil {
+				errAction = err
+				nextFn = fn_err
+				break
+			} else if c == '>' {
+				nextToken = XmlToken{XML_CDATA, val.String(), tkStart, xmlr.Pos()}
+				val.Reset()
+				nextFn = fn_ot2
+				returnToken = true
+				break
+			} else if c == ']' {
+				val.WriteRune(']')
+				continue
+			} else {
+				val.WriteString("]]")
+				val.WriteRune(c)
+				nextFn = fn_cd2
+				break
+			}
+		}
+	}
+
+	fn_dt = func() {
+		if raw, err := xmlr.readDoctype(); err != nil {
+			errAction = err
+			nextFn = fn_err
+		} else {
+			nextToken = XmlToken{XML_DOCTYPE, raw, tkStart, xmlr.Pos()}
+			errAction = xmlr.doctype(raw, tkStart.advance("<!DOCTYPE"))
+			nextFn = fn_cm6
+			returnToken = true
+		}
+	}
+
 	nextFn = fn_start
 
-	return XmlScanner(func() (XmlToken, error) {
+	return textSpace(XmlScanner(func() (XmlToken, error) {
 		if fgStopped || errAction != nil {
 			return XmlToken{}, errAction
 		}
@@ -419,10 +886,10 @@ func scanXml2(xml string) XmlScanner {
 			nextFn()
 			if returnToken {
 				returnToken = false
-				return nextToken, nil
+				return nextToken, errAction
 			}
 		}
 
 		return nextToken, errAction
-	})
+	}), xmlr)
 }
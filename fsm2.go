@@ -2,80 +2,108 @@ package xmlparser
 
 import (
 	"bytes"
-	"fmt"
+	"io"
+	"strings"
 )
 
 type fnscan func() fnscan
 
 type xmlscan struct {
-	xmlr      *bytes.Reader
-	err       error
-	tk        XmlToken
-	stopped   bool
-	started   bool
-	rtToken   bool
-	hasHeader bool
-	nextFn    fnscan
-	val       bytes.Buffer
+	xmlr    *xmlReader
+	err     error
+	tk      XmlToken
+	stopped bool
+	started bool
+	rtToken bool
+	atStart bool // the markup being scanned is the first of the document
+	quote   rune
+	tag     string
+	key     string // name of the property being scanned
+	depth   int    // number of open elements
+	tkStart Pos
+	state   string // state which found a syntax error
+	nextFn  fnscan
+	val     bytes.Buffer
+	opt     *options
 }
 
 func (xmls *xmlscan) dummy() fnscan {
 	return nil
 }
 
+// decode unescapes a text or property value starting at start.
+func (xmls *xmlscan) decode(s string, start Pos) (string, error) {
+	if !xmls.opt.decode {
+		return s, nil
+	}
+	return xmls.xmlr.unescape(s, start)
+}
+
+// decodeValue unescapes and normalizes the value of property xmls.key.
+func (xmls *xmlscan) decodeValue(s string, start Pos) (string, error) {
+	return xmls.xmlr.propValue(s, start, xmls.tag, xmls.key)
+}
+
 func (xmls *xmlscan) start() fnscan {
 	for {
 		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '<' {
+			xmls.atStart = true
 			return xmls.fn_lt
 		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 			continue
 		} else {
+			xmls.state = "start"
 			return xmls.fn_serr
 		}
 	}
 }
 
 func (xmls *xmlscan) fn_serr() fnscan {
-	syntaxErrOff := xmls.xmlr.Size() - int64(xmls.xmlr.Len())
-	xmls.err = fmt.Errorf("syntax error: at %v", syntaxErrOff)
+	xmls.err = xmls.xmlr.syntaxError(xmls.state)
 	return nil
 }
 
 func (xmls *xmlscan) fn_lt() fnscan {
+	xmls.tkStart = xmls.xmlr.lastPos()
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "lt"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '?' {
-			if !xmls.hasHeader {
-				xmls.hasHeader = true
-				return xmls.fn_h1
-			} else {
-				return xmls.fn_serr
-			}
+			return xmls.fn_h1
 		} else if c == '/' {
+			xmls.atStart = false
 			return xmls.fn_ct1
 		} else if c == '!' {
+			xmls.atStart = false
 			return xmls.fn_cm1
-		} else {
+		} else if isNameStartChar(c) {
+			xmls.atStart = false
 			xmls.val.WriteRune(c)
 			return xmls.fn_ot1
+		} else {
+			xmls.state = "lt"
+			return xmls.fn_serr
 		}
 	}
 }
 
 func (xmls *xmlscan) fn_h1() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "h1"
+			return xmls.fn_serr // unterminated processing instruction
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '?' {
 			return xmls.fn_h2
-		} else if c == '>' || c == '<' {
-			return xmls.fn_serr
 		} else {
 			xmls.val.WriteRune(c)
 		}
@@ -84,15 +112,23 @@ func (xmls *xmlscan) fn_h1() fnscan {
 
 func (xmls *xmlscan) fn_h2() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "h2"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '>' {
-			xmls.tk = XmlToken{XML_HEAD, xmls.val.String()}
+			xmls.tk = XmlToken{XML_PI, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.tk.ID, xmls.err = xmls.xmlr.procInst(xmls.tk.Val, xmls.tkStart, xmls.atStart)
+			xmls.atStart = false
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_h3
+		} else if c == '?' {
+			xmls.val.WriteRune('?')
 		} else {
+			xmls.val.WriteRune('?')
 			xmls.val.WriteRune(c)
 			return xmls.fn_h1
 		}
@@ -104,28 +140,73 @@ func (xmls *xmlscan) fn_h3() fnscan {
 		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
 			xmls.err = err
 			return nil
+		} else if c == '<' && xmls.val.Len() > 0 && !xmls.xmlr.dropped(xmls.val.Bytes()) {
+			// white space between elements
+			xmls.tk = XmlToken{XML_TEXT, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_lt
 		} else if c == '<' {
+			xmls.val.Reset() // white space the policy drops, if any
 			return xmls.fn_lt
+		} else if xmls.depth > 0 {
+			if xmls.val.Len() == 0 {
+				xmls.tkStart = xmls.xmlr.lastPos()
+			}
+			xmls.val.WriteRune(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				return xmls.fn_tt
+			}
 		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 			continue
 		} else {
+			xmls.state = "h3"
 			return xmls.fn_serr
 		}
 	}
 }
 func (xmls *xmlscan) fn_ct1() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "ct1"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
-		} else if c == '>' {
-			xmls.tk = XmlToken{XML_TAG_CLOSE, xmls.val.String()}
+		} else if c == '>' && xmls.val.Len() > 0 {
+			xmls.tk = XmlToken{XML_TAG_CLOSE, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.depth--
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_ct2
-		} else {
+		} else if isSpace(c) && xmls.val.Len() > 0 {
+			return xmls.fn_ct3
+		} else if isNameChar(c) && (xmls.val.Len() > 0 || isNameStartChar(c)) {
 			xmls.val.WriteRune(c)
 			continue
+		} else {
+			xmls.state = "ct1"
+			return xmls.fn_serr
+		}
+	}
+}
+func (xmls *xmlscan) fn_ct3() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "ct3"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '>' {
+			xmls.tk = XmlToken{XML_TAG_CLOSE, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.depth--
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_ct2
+		} else if !isSpace(c) {
+			xmls.state = "ct3"
+			return xmls.fn_serr
 		}
 	}
 }
@@ -134,33 +215,66 @@ func (xmls *xmlscan) fn_ct2() fnscan {
 		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
 			xmls.err = err
 			return nil
+		} else if c == '<' && xmls.val.Len() > 0 && !xmls.xmlr.dropped(xmls.val.Bytes()) {
+			// white space between elements
+			xmls.tk = XmlToken{XML_TEXT, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_lt
 		} else if c == '<' {
+			xmls.val.Reset() // white space the policy drops, if any
 			return xmls.fn_lt
+		} else if xmls.depth > 0 {
+			if xmls.val.Len() == 0 {
+				xmls.tkStart = xmls.xmlr.lastPos()
+			}
+			xmls.val.WriteRune(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				return xmls.fn_tt
+			}
 		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 			continue
 		} else {
+			xmls.state = "ct2"
 			return xmls.fn_serr
 		}
 	}
 }
 func (xmls *xmlscan) fn_ot1() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "ot1"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '>' {
-			xmls.tk = XmlToken{XML_TAG_OPTN, xmls.val.String()}
+			xmls.tag = xmls.val.String()
+			xmls.tk = XmlToken{XML_TAG_OPTN, xmls.tag, xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.depth++
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_ot2
-		} else if c == ' ' {
-			xmls.tk = XmlToken{XML_TAG_OPTN, xmls.val.String()}
+		} else if isSpace(c) {
+			xmls.tag = xmls.val.String()
+			xmls.tk = XmlToken{XML_TAG_OPTN, xmls.tag, xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.depth++
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_pt1
-		} else {
+		} else if c == '/' {
+			xmls.tag = xmls.val.String()
+			xmls.tk = XmlToken{XML_TAG_OPTN, xmls.tag, xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.depth++
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_et
+		} else if isNameChar(c) {
 			xmls.val.WriteRune(c)
 			continue
+		} else {
+			xmls.state = "ot1"
+			return xmls.fn_serr
 		}
 	}
 }
@@ -169,13 +283,28 @@ func (xmls *xmlscan) fn_ot2() fnscan {
 		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
 			xmls.err = err
 			return nil
-		} else if c == '\n' || c == '\r' || c == '\t' {
-			continue
+		} else if c == '<' && xmls.val.Len() > 0 && !xmls.xmlr.dropped(xmls.val.Bytes()) {
+			// white space between elements
+			xmls.tk = XmlToken{XML_TEXT, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_lt
 		} else if c == '<' {
+			xmls.val.Reset() // white space the policy drops, if any
 			return xmls.fn_lt
-		} else {
+		} else if xmls.depth > 0 {
+			if xmls.val.Len() == 0 {
+				xmls.tkStart = xmls.xmlr.lastPos()
+			}
 			xmls.val.WriteRune(c)
-			return xmls.fn_tt
+			if !isSpace(c) {
+				return xmls.fn_tt
+			}
+		} else if isSpace(c) {
+			continue
+		} else {
+			xmls.state = "ot2"
+			return xmls.fn_serr
 		}
 	}
 }
@@ -185,7 +314,8 @@ func (xmls *xmlscan) fn_tt() fnscan {
 			xmls.err = err
 			return nil
 		} else if c == '<' {
-			xmls.tk = XmlToken{XML_TEXT, xmls.val.String()}
+			xmls.tk = XmlToken{XML_TEXT, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.tk.Val, xmls.err = xmls.decode(xmls.tk.Val, xmls.tkStart)
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_lt
@@ -197,74 +327,226 @@ func (xmls *xmlscan) fn_tt() fnscan {
 }
 func (xmls *xmlscan) fn_pt1() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pt1"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
-		} else if c == '=' {
-			xmls.tk = XmlToken{XML_PRO_KEY, xmls.val.String()}
+		} else if c == '=' && xmls.val.Len() > 0 {
+			xmls.tk = XmlToken{XML_PRO_KEY, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.key = xmls.tk.Val
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_pt2
-		} else {
+		} else if isSpace(c) && xmls.val.Len() == 0 {
+			continue
+		} else if isSpace(c) {
+			xmls.tk = XmlToken{XML_PRO_KEY, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.key = xmls.tk.Val
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_pe
+		} else if c == '/' && xmls.val.Len() == 0 {
+			return xmls.fn_et
+		} else if c == '>' && xmls.val.Len() == 0 {
+			return xmls.fn_ot2
+		} else if isNameChar(c) && (xmls.val.Len() > 0 || isNameStartChar(c)) {
+			if xmls.val.Len() == 0 {
+				xmls.tkStart = xmls.xmlr.lastPos()
+			}
 			xmls.val.WriteRune(c)
 			continue
+		} else {
+			xmls.state = "pt1"
+			return xmls.fn_serr
+		}
+	}
+}
+func (xmls *xmlscan) fn_pe() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pe"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '=' {
+			return xmls.fn_pt2
+		} else if !isSpace(c) {
+			xmls.state = "pe"
+			return xmls.fn_serr
 		}
 	}
 }
 func (xmls *xmlscan) fn_pt2() fnscan {
+	if xmls.val.Len() == 0 {
+		xmls.tkStart = xmls.xmlr.Pos()
+	}
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pt2"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
-		} else if c == ' ' {
-			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String()}
+		} else if (c == '"' || c == '\'') && xmls.val.Len() == 0 {
+			xmls.quote = c
+			return xmls.fn_pq
+		} else if isSpace(c) && xmls.val.Len() == 0 {
+			xmls.tkStart = xmls.xmlr.Pos()
+			continue
+		} else if isSpace(c) {
+			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.tk.Val, xmls.err = xmls.decodeValue(xmls.tk.Val, xmls.tkStart)
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_pt1
 		} else if c == '>' {
-			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String()}
+			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.tk.Val, xmls.err = xmls.decodeValue(xmls.tk.Val, xmls.tkStart)
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_ot2
+		} else if c == '/' {
+			return xmls.fn_pt4
 		} else {
 			xmls.val.WriteRune(c)
 			continue
 		}
 	}
 }
+func (xmls *xmlscan) fn_pq() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pq"
+			return xmls.fn_serr // unterminated quoted value
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '<' {
+			xmls.state = "pq"
+			return xmls.fn_serr // no '<' in property values
+		} else if c == xmls.quote {
+			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.tk.Val, xmls.err = xmls.decodeValue(xmls.tk.Val, xmls.tkStart.advance(string(xmls.quote)))
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_pt3
+		} else {
+			xmls.val.WriteRune(c)
+			continue
+		}
+	}
+}
+func (xmls *xmlscan) fn_pt3() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pt3"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if isSpace(c) {
+			return xmls.fn_pt1
+		} else if c == '>' {
+			return xmls.fn_ot2
+		} else if c == '/' {
+			return xmls.fn_et
+		} else {
+			xmls.state = "pt3"
+			return xmls.fn_serr
+		}
+	}
+}
+func (xmls *xmlscan) fn_pt4() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "pt4"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '>' {
+			xmls.xmlr.UnreadRune()
+			xmls.tk = XmlToken{XML_PRO_VAL, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos().back()}
+			xmls.tk.Val, xmls.err = xmls.decodeValue(xmls.tk.Val, xmls.tkStart)
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_et
+		} else {
+			xmls.val.WriteRune('/')
+			xmls.xmlr.UnreadRune()
+			return xmls.fn_pt2
+		}
+	}
+}
+func (xmls *xmlscan) fn_et() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "et"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '>' {
+			xmls.tkStart = xmls.xmlr.lastPos().back() // the '/'
+			xmls.tk = XmlToken{XML_TAG_CLOSE, xmls.tag, xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.depth--
+			xmls.rtToken = true
+			return xmls.fn_ct2
+		} else {
+			xmls.state = "et"
+			return xmls.fn_serr
+		}
+	}
+}
 func (xmls *xmlscan) fn_cm1() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cm1"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '-' {
 			return xmls.fn_cm2
+		} else if c == '[' {
+			return xmls.fn_cd1
+		} else if c == 'D' {
+			return xmls.fn_dt
 		} else {
+			xmls.state = "cm1"
 			return xmls.fn_serr
 		}
 	}
 }
 func (xmls *xmlscan) fn_cm2() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cm2"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '-' {
 			return xmls.fn_cm3
 		} else {
+			xmls.state = "cm2"
 			return xmls.fn_serr
 		}
 	}
 }
 func (xmls *xmlscan) fn_cm3() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cm3"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '-' {
 			return xmls.fn_cm4
-		} else if c == '<' || c == '>' {
-			return xmls.fn_serr
 		} else {
 			xmls.val.WriteRune(c)
 			continue
@@ -273,7 +555,10 @@ func (xmls *xmlscan) fn_cm3() fnscan {
 }
 func (xmls *xmlscan) fn_cm4() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cm4"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '-' {
@@ -287,14 +572,23 @@ func (xmls *xmlscan) fn_cm4() fnscan {
 }
 func (xmls *xmlscan) fn_cm5() fnscan {
 	for {
-		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cm5"
+			return xmls.fn_serr
+		} else if err != nil {
 			xmls.err = err
 			return nil
 		} else if c == '>' {
-			xmls.tk = XmlToken{XML_COMMENT, xmls.val.String()}
+			xmls.tk = XmlToken{XML_COMMENT, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
 			xmls.val.Reset()
 			xmls.rtToken = true
 			return xmls.fn_cm6
+		} else if xmls.opt.strict {
+			xmls.state = "cm5"
+			return xmls.fn_serr // "--" inside the comment
+		} else if c == '-' {
+			xmls.val.WriteRune('-')
+			continue
 		} else {
 			xmls.val.WriteString("--")
 			xmls.val.WriteRune(c)
@@ -307,28 +601,127 @@ func (xmls *xmlscan) fn_cm6() fnscan {
 		if c, _, err := xmls.xmlr.ReadRune(); err != nil {
 			xmls.err = err
 			return nil
+		} else if c == '<' && xmls.val.Len() > 0 && !xmls.xmlr.dropped(xmls.val.Bytes()) {
+			// white space between elements
+			xmls.tk = XmlToken{XML_TEXT, xmls.val.String(), xmls.tkStart, xmls.xmlr.lastPos()}
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_lt
 		} else if c == '<' {
+			xmls.val.Reset() // white space the policy drops, if any
 			return xmls.fn_lt
+		} else if xmls.depth > 0 {
+			if xmls.val.Len() == 0 {
+				xmls.tkStart = xmls.xmlr.lastPos()
+			}
+			xmls.val.WriteRune(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				return xmls.fn_tt
+			}
 		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 			continue
 		} else {
+			xmls.state = "cm6"
+			return xmls.fn_serr
+		}
+	}
+}
+func (xmls *xmlscan) fn_cd1() fnscan {
+	for i := 0; i < len(cdataStart); i++ {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cd1"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c != rune(cdataStart[i]) {
+			xmls.state = "cd1"
+			return xmls.fn_serr
+		}
+	}
+	return xmls.fn_cd2
+}
+func (xmls *xmlscan) fn_cd2() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cd2"
+			return xmls.fn_serr // unterminated CDATA section
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == ']' {
+			return xmls.fn_cd3
+		} else {
+			xmls.val.WriteRune(c)
+			continue
+		}
+	}
+}
+func (xmls *xmlscan) fn_cd3() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cd3"
+			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == ']' {
+			return xmls.fn_cd4
+		} else {
+			xmls.val.WriteRune(']')
+			xmls.val.WriteRune(c)
+			return xmls.fn_cd2
+		}
+	}
+}
+func (xmls *xmlscan) fn_cd4() fnscan {
+	for {
+		if c, _, err := xmls.xmlr.ReadRune(); err == io.EOF {
+			xmls.state = "cd4"
 			return xmls.fn_serr
+		} else if err != nil {
+			xmls.err = err
+			return nil
+		} else if c == '>' {
+			xmls.tk = XmlToken{XML_CDATA, xmls.val.String(), xmls.tkStart, xmls.xmlr.Pos()}
+			xmls.val.Reset()
+			xmls.rtToken = true
+			return xmls.fn_ot2
+		} else if c == ']' {
+			xmls.val.WriteRune(']')
+			continue
+		} else {
+			xmls.val.WriteString("]]")
+			xmls.val.WriteRune(c)
+			return xmls.fn_cd2
 		}
 	}
 }
+func (xmls *xmlscan) fn_dt() fnscan {
+	raw, err := xmls.xmlr.readDoctype()
+	if err != nil {
+		xmls.err = err
+		return nil
+	}
+	xmls.tk = XmlToken{XML_DOCTYPE, raw, xmls.tkStart, xmls.xmlr.Pos()}
+	xmls.err = xmls.xmlr.doctype(raw, xmls.tkStart.advance("<!DOCTYPE"))
+	xmls.rtToken = true
+	return xmls.fn_cm6
+}
 
-func newXmlScan(xml string) *xmlscan {
+func newXmlScan(xml string, opts ...Option) *xmlscan {
 	xmls := &xmlscan{}
-	xmls.xmlr = bytes.NewReader([]byte(xml))
+	xmls.opt = newOptions(opts)
+	xmls.xmlr = newXmlReader(strings.NewReader(xml), xmls.opt)
 	xmls.nextFn = xmls.start
 	return xmls
 }
 
-func scanXml3(xml string) XmlScanner {
-	xmls := newXmlScan(xml)
-	return XmlScanner(func() (XmlToken, error) {
+func scanXml3(xml string, opts ...Option) XmlScanner {
+	xmls := newXmlScan(xml, opts...)
+	return textSpace(XmlScanner(func() (XmlToken, error) {
 		if xmls.stopped || xmls.err != nil {
-			return xmls.tk, xmls.err
+			return XmlToken{}, xmls.err
 		}
 
 		for !xmls.stopped && xmls.err == nil && xmls.nextFn != nil {
@@ -340,5 +733,5 @@ func scanXml3(xml string) XmlScanner {
 		}
 
 		return xmls.tk, xmls.err
-	})
+	}), xmls.xmlr)
 }
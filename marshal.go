@@ -0,0 +1,302 @@
+package xmlparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Marshal returns the node and its descendants as XML text which parses
+// back into an equivalent tree. Property values are quoted and, like
+// text, escaped; comments, PIs and CDATA sections are written as they
+// are. Nothing is added for indentation, and white space the parser
+// drops under its space policy is dropped again when the output is
+// parsed. A XN_Dummy node is written as the sequence of its children.
+//
+// The output is UTF-8, so an encoding other than UTF-8 is left out of
+// the XML declaration. Namespace prefixes must be declared by the
+// elements written or by ancestors of node; declarations of ancestors
+// are added to the elements which need them. Marshal fails on content
+// which cannot be written as well-formed XML, like a comment containing
+// "--" or an undeclared prefix.
+func Marshal(node *XmlNode) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeNode(&b, node, &nsScope{}, outerScope(node)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalDocument returns the document doc as XML text like Marshal.
+func MarshalDocument(doc *Document) ([]byte, error) {
+	if doc.root == nil {
+		return nil, ErrNoRoot
+	}
+	var b bytes.Buffer
+	for _, n := range doc.nodes() {
+		if err := writeNode(&b, n, &nsScope{}, &nsScope{}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// WriteTo writes the node to w as XML text, see Marshal.
+func (node *XmlNode) WriteTo(w io.Writer) (int64, error) {
+	b, err := Marshal(node)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// WriteTo writes the document to w as XML text, see MarshalDocument.
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	b, err := MarshalDocument(doc)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// outerScope returns the namespace declarations of the ancestors of
+// node, which are in scope for node but not written with it.
+func outerScope(node *XmlNode) *nsScope {
+	var anc []*XmlNode
+	for n := node.parent; n != nil; n = n.parent {
+		anc = append(anc, n)
+	}
+	sc := &nsScope{}
+	for i := len(anc) - 1; i >= 0; i-- {
+		if anc[i].ntype == XN_Tag {
+			sc.startElement(startTokens(anc[i], nil))
+		}
+	}
+	return sc
+}
+
+// startTokens returns the tokens of the start tag of the element node,
+// with the declarations decls added to its properties.
+func startTokens(node *XmlNode, decls []*XmlNode) []NsToken {
+	tks := []NsToken{{XmlToken: XmlToken{ID: XML_TAG_OPTN, Val: node.name}}}
+	for _, p := range append(node.prop[:len(node.prop):len(node.prop)], decls...) {
+		tks = append(tks,
+			NsToken{XmlToken: XmlToken{ID: XML_PRO_KEY, Val: p.name}},
+			NsToken{XmlToken: XmlToken{ID: XML_PRO_VAL, Val: p.value}})
+	}
+	return tks
+}
+
+// missingDecls returns the namespace declarations to add to the
+// element node for the prefixes it uses which are declared in outer
+// only, not in the written scope sc.
+func missingDecls(node *XmlNode, sc, outer *nsScope) []*XmlNode {
+	declared := func(prefix string) bool {
+		key := "xmlns:" + prefix
+		if prefix == "" {
+			key = "xmlns"
+		}
+		if _, ok := node.Attr(key); ok {
+			return true
+		}
+		for _, bd := range sc.bindings {
+			if bd.prefix == prefix {
+				return true
+			}
+		}
+		return false
+	}
+
+	var decls []*XmlNode
+	need := func(prefix string) {
+		if prefix == "xml" || prefix == "xmlns" || declared(prefix) {
+			return
+		}
+		uri, ok := outer.lookup(prefix)
+		if !ok || uri == "" {
+			return
+		}
+		key := "xmlns:" + prefix
+		if prefix == "" {
+			key = "xmlns"
+		}
+		for _, d := range decls {
+			if d.name == key {
+				return
+			}
+		}
+		decls = append(decls, &XmlNode{ntype: XN_Prop, name: key, value: uri})
+	}
+
+	need(splitName(node.name).Prefix)
+	for _, p := range node.prop {
+		if prefix := splitName(p.name).Prefix; prefix != "" {
+			need(prefix)
+		}
+	}
+	return decls
+}
+
+// writeNode writes node to b. sc holds the namespace declarations of
+// the elements written around node, outer those of the ancestors which
+// are not written.
+func writeNode(b *bytes.Buffer, node *XmlNode, sc, outer *nsScope) error {
+	fail := func(msg string, args ...interface{}) error {
+		return fmt.Errorf("xmlparser: cannot write %v: %v", node.Path(), fmt.Sprintf(msg, args...))
+	}
+
+	switch node.ntype {
+	case XN_Dummy:
+		for _, n := range node.sube {
+			if err := writeNode(b, n, sc, outer); err != nil {
+				return err
+			}
+		}
+
+	case XN_Head:
+		decl := node.Decl()
+		if decl.Version == "" {
+			decl.Version = "1.0"
+		}
+		b.WriteString(`<?xml version="` + decl.Version + `"`)
+		if strings.EqualFold(decl.Encoding, "UTF-8") {
+			b.WriteString(` encoding="` + decl.Encoding + `"`)
+		}
+		if decl.Standalone != "" {
+			b.WriteString(` standalone="` + decl.Standalone + `"`)
+		}
+		b.WriteString("?>")
+
+	case XN_Doctype:
+		b.WriteString("<!DOCTYPE" + node.value + ">")
+
+	case XN_Tag:
+		if !isName(node.name) {
+			return fail("invalid element name %q", node.name)
+		}
+		decls := missingDecls(node, sc, outer)
+		if err := sc.startElement(startTokens(node, decls)); err != nil {
+			if se, ok := err.(*SyntaxError); ok {
+				return fail("%v in %q", se.Msg, se.Found)
+			}
+			return fail("%v", err)
+		}
+		defer sc.endElement()
+
+		b.WriteString("<" + node.name)
+		for _, p := range append(node.prop[:len(node.prop):len(node.prop)], decls...) {
+			if !isName(p.name) {
+				return fail("invalid property name %q", p.name)
+			}
+			b.WriteString(" " + p.name + `="`)
+			if c, ok := escape(b, p.value, true); !ok {
+				return fail("invalid character %q in property %v", c, p.name)
+			}
+			b.WriteByte('"')
+		}
+		if len(node.sube) == 0 {
+			b.WriteString("/>")
+			return nil
+		}
+		b.WriteByte('>')
+		for _, n := range node.sube {
+			if err := writeNode(b, n, sc, outer); err != nil {
+				return err
+			}
+		}
+		b.WriteString("</" + node.name + ">")
+
+	case XN_Text:
+		if c, ok := escape(b, node.name, false); !ok {
+			return fail("invalid character %q", c)
+		}
+
+	case XN_CData:
+		if c, ok := checkChars(node.name); !ok {
+			return fail("invalid character %q", c)
+		}
+		// "]]>" cannot be in a CDATA section, so it is split in two
+		b.WriteString("<![CDATA[" + strings.ReplaceAll(node.name, "]]>", "]]]]><![CDATA[>") + "]]>")
+
+	case XN_Comment:
+		if strings.Contains(node.name, "--") || strings.HasSuffix(node.name, "-") {
+			return fail(`comment containing "--" or ending in "-"`)
+		}
+		if c, ok := checkChars(node.name); !ok {
+			return fail("invalid character %q", c)
+		}
+		b.WriteString("<!--" + node.name + "-->")
+
+	case XN_PI:
+		if !isName(node.name) || strings.EqualFold(node.name, "xml") {
+			return fail("invalid processing instruction target %q", node.name)
+		}
+		if strings.Contains(node.value, "?>") {
+			return fail(`processing instruction containing "?>"`)
+		}
+		if c, ok := checkChars(node.value); !ok {
+			return fail("invalid character %q", c)
+		}
+		b.WriteString("<?" + node.name)
+		if node.value != "" {
+			b.WriteString(" " + node.value)
+		}
+		b.WriteString("?>")
+
+	default:
+		return fail("node of type %d out of place", node.ntype)
+	}
+	return nil
+}
+
+// escape writes s to b with the markup characters replaced by entity
+// references. Characters which the parser would normalize are written
+// as character references: a carriage return everywhere, and tabs and
+// line feeds in property values too. It returns a character which
+// cannot be in XML and false if s has one.
+func escape(b *bytes.Buffer, s string, attr bool) (rune, bool) {
+	for _, c := range s {
+		switch {
+		case c == '&':
+			b.WriteString("&amp;")
+		case c == '<':
+			b.WriteString("&lt;")
+		case c == '>':
+			b.WriteString("&gt;")
+		case c == '"' && attr:
+			b.WriteString("&quot;")
+		case c == '\r' || attr && (c == '\t' || c == '\n'):
+			b.WriteString("&#" + strconv.Itoa(int(c)) + ";")
+		case !isXmlChar(c):
+			return c, false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return 0, true
+}
+
+// checkChars returns a character of s which cannot be in XML and
+// false if there is one.
+func checkChars(s string) (rune, bool) {
+	for _, c := range s {
+		if !isXmlChar(c) {
+			return c, false
+		}
+	}
+	return 0, true
+}
+
+// isName reports whether s is an XML name.
+func isName(s string) bool {
+	for i, c := range s {
+		if i == 0 && !isNameStartChar(c) || !isNameChar(c) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,129 @@
+package xmlparser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parent returns the node the node is a child or property of, nil for
+// the root of a tree.
+func (node *XmlNode) Parent() *XmlNode {
+	return node.parent
+}
+
+// NextSibling returns the child of the parent following the node, nil
+// for the last child or a node without parent. Properties have no
+// siblings.
+func (node *XmlNode) NextSibling() *XmlNode {
+	return node.sibling(1)
+}
+
+// PrevSibling returns the child of the parent preceding the node, nil
+// for the first child or a node without parent.
+func (node *XmlNode) PrevSibling() *XmlNode {
+	return node.sibling(-1)
+}
+
+func (node *XmlNode) sibling(d int) *XmlNode {
+	if node.parent == nil {
+		return nil
+	}
+	i := node.parent.index(node)
+	if i < 0 || i+d < 0 || i+d >= len(node.parent.sube) {
+		return nil
+	}
+	return node.parent.sube[i+d]
+}
+
+// FirstChild returns the first child of the node, nil if it has none.
+func (node *XmlNode) FirstChild() *XmlNode {
+	if len(node.sube) == 0 {
+		return nil
+	}
+	return node.sube[0]
+}
+
+// LastChild returns the last child of the node, nil if it has none.
+func (node *XmlNode) LastChild() *XmlNode {
+	if len(node.sube) == 0 {
+		return nil
+	}
+	return node.sube[len(node.sube)-1]
+}
+
+// Depth returns the number of ancestors of the node: 0 for the root of
+// a tree, 1 for the root element of a tree returned by ParseXml.
+func (node *XmlNode) Depth() int {
+	d := 0
+	for n := node.parent; n != nil; n = n.parent {
+		d++
+	}
+	return d
+}
+
+// Path returns the location of the node in its tree as an XPath
+// expression, like /catalog/book[2]/@id. An element without parent,
+// like the root element of a Document, is taken for the root element.
+// Elements are numbered among the siblings of the same name, other
+// nodes among the siblings of the same type, only where there is more
+// than one.
+func (node *XmlNode) Path() string {
+	var steps []string
+	for n := node; n != nil; n = n.parent {
+		if n.parent == nil && n.ntype != XN_Tag {
+			break
+		}
+		steps = append(steps, n.step())
+	}
+	if len(steps) == 0 {
+		return "/"
+	}
+
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteByte('/')
+		b.WriteString(steps[i])
+	}
+	return b.String()
+}
+
+// step returns the location step of the node in its parent.
+func (node *XmlNode) step() string {
+	var step string
+	switch node.ntype {
+	case XN_Prop:
+		return "@" + node.name
+	case XN_Tag:
+		step = node.name
+	case XN_Text, XN_CData:
+		step = "text()"
+	case XN_Comment:
+		step = "comment()"
+	case XN_PI:
+		step = "processing-instruction()"
+	default:
+		step = "node()"
+	}
+
+	if node.parent == nil {
+		return step
+	}
+	pos, count := 0, 0
+	for _, n := range node.parent.sube {
+		if n.ntype == node.ntype && (n.ntype != XN_Tag || n.name == node.name) ||
+			isTextNode(n) && isTextNode(node) {
+			count++
+			if n == node {
+				pos = count
+			}
+		}
+	}
+	if count > 1 {
+		step += "[" + strconv.Itoa(pos) + "]"
+	}
+	return step
+}
+
+func isTextNode(n *XmlNode) bool {
+	return n.ntype == XN_Text || n.ntype == XN_CData
+}
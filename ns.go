@@ -0,0 +1,226 @@
+package xmlparser
+
+import "strings"
+
+// namespaces bound by the Namespaces in XML recommendation
+const (
+	XmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+	XmlnsNamespace = "http://www.w3.org/2000/xmlns/"
+)
+
+// QName is the namespace-qualified name of an element or attribute.
+type QName struct {
+	Space  string // resolved namespace URI, empty for no namespace
+	Prefix string // prefix as written, empty if none
+	Local  string // name without the prefix
+}
+
+func (qn QName) String() string {
+	if qn.Prefix == "" {
+		return qn.Local
+	}
+	return qn.Prefix + ":" + qn.Local
+}
+
+// NsToken is a token with the name of an element or attribute resolved
+// against the namespace declarations in scope.
+type NsToken struct {
+	XmlToken
+	Name QName // set for XML_TAG_OPTN, XML_TAG_CLOSE and XML_PRO_KEY
+}
+
+type NsScanner func() (NsToken, error)
+
+// NewNsScanner returns a scanner which resolves the element and
+// property names of the tokens of scan. The xmlns and xmlns:prefix
+// properties of a start tag declare namespaces for the element and its
+// content; unprefixed property names are in no namespace. A prefix
+// which is not declared is reported as a *SyntaxError.
+func NewNsScanner(scan XmlScanner) NsScanner {
+	var scope nsScope
+	var queue []NsToken // resolved tokens of a start tag
+	var next XmlToken   // token read past the start tag
+	var nextErr error
+	var pending bool
+	var failed error
+
+	read := func() (XmlToken, error) {
+		if pending {
+			pending = false
+			return next, nextErr
+		}
+		return scan()
+	}
+
+	return NsScanner(func() (NsToken, error) {
+		if failed != nil {
+			return NsToken{}, failed
+		}
+		if len(queue) > 0 {
+			tk := queue[0]
+			queue = queue[1:]
+			return tk, nil
+		}
+
+		tk, err := read()
+		if err != nil {
+			return NsToken{XmlToken: tk}, err
+		}
+		switch tk.ID {
+		case XML_TAG_OPTN:
+			// the properties of the start tag may declare the
+			// namespace of the tag name, so read them first
+			queue = append(queue[:0], NsToken{XmlToken: tk})
+			for {
+				next, nextErr = scan()
+				if nextErr != nil || (next.ID != XML_PRO_KEY && next.ID != XML_PRO_VAL) {
+					pending = true
+					break
+				}
+				queue = append(queue, NsToken{XmlToken: next})
+			}
+			if failed = scope.startElement(queue); failed != nil {
+				queue = queue[:0]
+				return NsToken{XmlToken: tk}, failed
+			}
+			ntk := queue[0]
+			queue = queue[1:]
+			return ntk, nil
+
+		case XML_TAG_CLOSE:
+			name, err := scope.resolve(tk.Val, tk.Start, false)
+			scope.endElement()
+			if err != nil {
+				failed = err
+				return NsToken{XmlToken: tk}, err
+			}
+			return NsToken{tk, name}, nil
+		}
+		return NsToken{XmlToken: tk}, nil
+	})
+}
+
+// nsBinding binds a prefix, empty for the default namespace, to a
+// namespace URI.
+type nsBinding struct {
+	prefix string
+	uri    string
+}
+
+// nsScope is the stack of namespace declarations of the open elements.
+type nsScope struct {
+	bindings []nsBinding
+	marks    []int // len(bindings) at the start of each open element
+}
+
+// startElement opens the element of the start tag tks, its
+// XML_TAG_OPTN token followed by the property tokens, and resolves
+// their names in place.
+func (sc *nsScope) startElement(tks []NsToken) error {
+	sc.marks = append(sc.marks, len(sc.bindings))
+	for i, tk := range tks {
+		if tk.ID != XML_PRO_KEY || (tk.Val != "xmlns" && !strings.HasPrefix(tk.Val, "xmlns:")) {
+			continue
+		}
+		var uri string
+		if i+1 < len(tks) && tks[i+1].ID == XML_PRO_VAL {
+			uri = tks[i+1].Val
+		}
+		prefix := strings.TrimPrefix(tk.Val[len("xmlns"):], ":")
+		if msg := checkBinding(tk.Val, prefix, uri); msg != "" {
+			return &SyntaxError{Pos: tk.Start, Found: tk.Val, Msg: msg}
+		}
+		sc.bindings = append(sc.bindings, nsBinding{prefix, uri})
+	}
+
+	for i := range tks {
+		var err error
+		switch tks[i].ID {
+		case XML_TAG_OPTN:
+			tks[i].Name, err = sc.resolve(tks[i].Val, tks[i].Start, false)
+		case XML_PRO_KEY:
+			tks[i].Name, err = sc.resolve(tks[i].Val, tks[i].Start, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endElement closes the innermost open element.
+func (sc *nsScope) endElement() {
+	if n := len(sc.marks); n > 0 {
+		sc.bindings = sc.bindings[:sc.marks[n-1]]
+		sc.marks = sc.marks[:n-1]
+	}
+}
+
+// checkBinding returns what is wrong with the declaration key binding
+// prefix to uri, or "".
+func checkBinding(key, prefix, uri string) string {
+	switch {
+	case key != "xmlns" && (prefix == "" || strings.IndexByte(prefix, ':') >= 0):
+		return "invalid namespace declaration"
+	case prefix == "xmlns":
+		return "the xmlns prefix cannot be declared"
+	case prefix == "xml" && uri != XmlNamespace:
+		return "the xml prefix cannot be bound to " + uri
+	case prefix != "xml" && (uri == XmlNamespace || uri == XmlnsNamespace):
+		return "namespace " + uri + " is reserved"
+	case prefix != "" && uri == "":
+		return "empty namespace for prefix " + prefix
+	}
+	return ""
+}
+
+// lookup returns the namespace URI bound to prefix.
+func (sc *nsScope) lookup(prefix string) (string, bool) {
+	for i := len(sc.bindings) - 1; i >= 0; i-- {
+		if sc.bindings[i].prefix == prefix {
+			return sc.bindings[i].uri, true
+		}
+	}
+	switch prefix {
+	case "":
+		return "", true
+	case "xml":
+		return XmlNamespace, true
+	}
+	return "", false
+}
+
+// resolve splits the element or property name raw, which starts at
+// input position start, and looks up its namespace.
+func (sc *nsScope) resolve(raw string, start Pos, attr bool) (QName, error) {
+	colon := strings.IndexByte(raw, ':')
+	if colon < 0 {
+		switch {
+		case attr && raw == "xmlns":
+			return QName{Space: XmlnsNamespace, Local: raw}, nil
+		case attr:
+			return QName{Local: raw}, nil
+		}
+		uri, _ := sc.lookup("")
+		return QName{Space: uri, Local: raw}, nil
+	}
+
+	qn := QName{Prefix: raw[:colon], Local: raw[colon+1:]}
+	if qn.Prefix == "" || qn.Local == "" || strings.IndexByte(qn.Local, ':') >= 0 {
+		return qn, &SyntaxError{Pos: start, Found: raw, Msg: "invalid qualified name"}
+	}
+	if qn.Prefix == "xmlns" {
+		if !attr {
+			return qn, &SyntaxError{Pos: start, Found: raw, Msg: "element name with the xmlns prefix"}
+		}
+		qn.Space = XmlnsNamespace
+		return qn, nil
+	}
+	uri, ok := sc.lookup(qn.Prefix)
+	if !ok {
+		return qn, &SyntaxError{Pos: start, Found: raw,
+			Msg: "undeclared namespace prefix " + qn.Prefix}
+	}
+	qn.Space = uri
+	return qn, nil
+}
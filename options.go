@@ -0,0 +1,105 @@
+package xmlparser
+
+import "io"
+
+// options holds the settings shared by the scanners and the tree builder.
+type options struct {
+	decode      bool // decode entity and character references
+	strict      bool // check the well-formedness of the whole document
+	normalize   bool // normalize line endings and property values
+	space       SpacePolicy
+	entityDepth int   // maximum nesting of entity expansions
+	entitySize  int64 // maximum bytes of replacement text per document
+
+	charsetReader func(charset string, input io.Reader) (io.Reader, error)
+}
+
+// default limits of entity expansion
+const (
+	defaultEntityDepth = 16
+	defaultEntitySize  = 1 << 20
+)
+
+// Option configures a scanner or ParseXml.
+type Option func(*options)
+
+// DecodeEntities switches decoding of the predefined entities, the
+// internal entities declared in the DOCTYPE and character references
+// in XML_TEXT and XML_PRO_VAL tokens on or off.
+// Decoding is on by default; turn it off to get the text as written.
+func DecodeEntities(on bool) Option {
+	return func(o *options) {
+		o.decode = on
+	}
+}
+
+// Normalize switches the normalization of the XML specification on or
+// off. When on, the default, CRLF and lone CR are read as LF everywhere,
+// and in property values each whitespace character which is not
+// written as a character reference becomes a space; values of
+// properties declared in the DOCTYPE with a type other than CDATA are
+// also trimmed and runs of spaces collapsed. Normalize(false) keeps the
+// raw text.
+func Normalize(on bool) Option {
+	return func(o *options) {
+		o.normalize = on
+	}
+}
+
+// TextSpace sets the handling of white space in XML_TEXT tokens to p;
+// the default is SpaceDefault. Text inside an element with
+// xml:space="preserve" is always kept as is. Start and End of a token
+// keep spanning the text as written.
+func TextSpace(p SpacePolicy) Option {
+	return func(o *options) {
+		o.space = p
+	}
+}
+
+// Strict switches the checks of the document structure on or off:
+// with Strict(true), ParseXml and ParseReader reject documents without
+// or with more than one root element, with duplicate properties, with
+// text outside the root element or with elements left open at the end
+// of the input, and the scanners reject "--" inside comments and white
+// space before the XML declaration. Strict mode is off by default.
+func Strict(on bool) Option {
+	return func(o *options) {
+		o.strict = on
+	}
+}
+
+// EntityLimits bounds the expansion of the entities declared in the
+// DOCTYPE: depth is the maximum nesting of entity references and size
+// the maximum number of bytes of replacement text in the document.
+// Exceeding either fails the scan with a *SyntaxError. The defaults
+// are 16 levels and 1 MiB.
+func EntityLimits(depth int, size int64) Option {
+	return func(o *options) {
+		o.entityDepth = depth
+		o.entitySize = size
+	}
+}
+
+// CharsetReader sets the function which converts input in the encoding
+// charset, as named by the XML declaration, to UTF-8. It is called for
+// encodings other than UTF-8, UTF-16, ISO-8859-1 and US-ASCII with the
+// input following the declaration; an error fails the scan.
+func CharsetReader(f func(charset string, input io.Reader) (io.Reader, error)) Option {
+	return func(o *options) {
+		o.charsetReader = f
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		decode:      true,
+		normalize:   true,
+		space:       SpaceDefault,
+		entityDepth: defaultEntityDepth,
+		entitySize:  defaultEntitySize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,334 @@
+package xmlparser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// size of the read buffer between the input and the scanners
+const readBufSize = 64 << 10
+
+// Pos is a position in the input.
+type Pos struct {
+	Offset int64 // byte offset, starting at 0; in the UTF-8 text for input converted by a CharsetReader
+	Line   int   // line number, starting at 1
+	Col    int   // column in runes, starting at 1
+
+	wide bool // the input is UTF-16, whose runes take 2 or 4 bytes
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// next returns the position following the rune c of size bytes at p.
+func (p Pos) next(c rune, size int) Pos {
+	p.Offset += int64(size)
+	if c == '\n' {
+		p.Line++
+		p.Col = 1
+	} else {
+		p.Col++
+	}
+	return p
+}
+
+// back returns the position of the ASCII rune just before p on the
+// same line.
+func (p Pos) back() Pos {
+	p.Offset--
+	if p.wide {
+		p.Offset--
+	}
+	p.Col--
+	return p
+}
+
+// advance returns the position following the text s at p. s is UTF-8;
+// the offset counts the bytes s takes in the input.
+func (p Pos) advance(s string) Pos {
+	for len(s) > 0 {
+		c, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if p.wide {
+			size = utf16Len(c)
+		}
+		p = p.next(c, size)
+	}
+	return p
+}
+
+// utf16Len returns the number of bytes of the rune c in UTF-16.
+func utf16Len(c rune) int {
+	if n := utf16.RuneLen(c); n > 0 {
+		return 2 * n
+	}
+	return 2 // utf8.RuneError for a lone surrogate
+}
+
+// xmlReader feeds input runes to the scanners through a bounded buffer
+// and keeps track of the input position.
+type xmlReader struct {
+	r      *bufio.Reader
+	win    []byte // plain input peeked from r, whose first n bytes are read
+	n      int
+	enc    int      // input encoding, one of the enc constants
+	at     Pos      // position of the last rune read; before the first, of the column before it
+	last   rune     // last rune read
+	size   int      // input size of last
+	unread bool     // last is read again next
+	plain  bool     // the input is UTF-8, read without decoding
+	eof    bool     // the end of input has been reached
+	dtd    *Doctype // DOCTYPE declaration of the document, if any
+	opt    *options
+
+	// UTF-8 text of the last runes read, for the excerpts of errors
+	recent    [recentSize]byte
+	recentEnd int64 // number of bytes written to recent
+	lineStart int64 // value of recentEnd at the start of the current line
+
+	expanded  int64  // bytes of entity replacement text produced so far
+	sniffed   bool   // the start of the input has been checked for its encoding
+	detected  string // encoding detected by sniff, if any
+	dropSpace string // white space only text of these characters is dropped by textSpace
+}
+
+// size of the text kept for excerpts, a power of 2 enough for
+// excerptLen bytes before the rune of an error
+const recentSize = 64
+
+// input encodings decoded by xmlReader
+const (
+	encUTF8 int = iota
+	encLatin1
+	encASCII
+)
+
+func newXmlReader(r io.Reader, opt *options) *xmlReader {
+	size := readBufSize
+	if sr, ok := r.(*strings.Reader); ok && sr.Len() < size {
+		size = sr.Len()
+	}
+	return &xmlReader{
+		r:   bufReader(r, size),
+		at:  Pos{Line: 1},
+		opt: opt,
+	}
+}
+
+// bufReader returns r buffered with size bytes, r itself if it is a
+// *bufio.Reader already.
+func bufReader(r io.Reader, size int) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// ReadRune reads the next rune. Unless the raw text is kept, a carriage
+// return, alone or followed by a line feed, is read as a line feed.
+func (xr *xmlReader) ReadRune() (c rune, size int, err error) {
+	if xr.unread {
+		xr.unread = false
+		return xr.last, xr.size, nil
+	}
+	if !xr.plain {
+		c, size, err = xr.read()
+	} else if xr.n < len(xr.win) && xr.win[xr.n] < utf8.RuneSelf {
+		c, size = rune(xr.win[xr.n]), 1
+		xr.n++
+	} else {
+		c, size, err = xr.readPlain()
+	}
+	if err != nil {
+		xr.eof = err == io.EOF
+		return
+	}
+	if c == '\r' && xr.opt.normalize {
+		c, size = xr.newline(size)
+	}
+
+	if xr.last == '\n' {
+		xr.lineStart = xr.recentEnd
+	}
+	if c < utf8.RuneSelf {
+		xr.recent[xr.recentEnd&(recentSize-1)] = byte(c)
+		xr.recentEnd++
+	} else {
+		xr.keep(c)
+	}
+	xr.at = xr.at.next(xr.last, xr.size)
+	xr.last, xr.size = c, size
+	return
+}
+
+// keep adds the UTF-8 bytes of the rune c to the recent text.
+func (xr *xmlReader) keep(c rune) {
+	var b [utf8.UTFMax]byte
+	for _, x := range b[:utf8.EncodeRune(b[:], c)] {
+		xr.recent[xr.recentEnd&(recentSize-1)] = x
+		xr.recentEnd++
+	}
+}
+
+// line returns the recent text of the current line, up to and
+// including the last rune read.
+func (xr *xmlReader) line() string {
+	from := max(xr.lineStart, xr.recentEnd-recentSize)
+	b := make([]byte, 0, xr.recentEnd-from)
+	for i := from; i < xr.recentEnd; i++ {
+		b = append(b, xr.recent[i&(recentSize-1)])
+	}
+	return string(b)
+}
+
+// read decodes the next rune of input which is not plain UTF-8, or
+// whose encoding has not been sniffed yet.
+func (xr *xmlReader) read() (c rune, size int, err error) {
+	if !xr.sniffed {
+		xr.sniff()
+		xr.plain = !xr.at.wide && xr.enc == encUTF8
+	}
+	if xr.at.wide {
+		c, _, err = xr.r.ReadRune()
+		size = utf16Len(c)
+	} else if xr.enc == encUTF8 {
+		c, size, err = xr.r.ReadRune()
+	} else {
+		var b byte
+		b, err = xr.r.ReadByte()
+		c, size = rune(b), 1
+		if xr.enc == encASCII && b >= utf8.RuneSelf {
+			c = utf8.RuneError
+		}
+	}
+	return
+}
+
+// readPlain decodes the next rune of plain UTF-8 input, moving the
+// window forward when fewer than utf8.UTFMax bytes are left in it.
+func (xr *xmlReader) readPlain() (rune, int, error) {
+	if len(xr.win)-xr.n < utf8.UTFMax {
+		xr.release()
+		_, err := xr.r.Peek(utf8.UTFMax)
+		if xr.win, _ = xr.r.Peek(xr.r.Buffered()); len(xr.win) == 0 {
+			return 0, 0, err
+		}
+	}
+	c, size := utf8.DecodeRune(xr.win[xr.n:])
+	xr.n += size
+	return c, size, nil
+}
+
+// release discards the bytes read from the window from r, which must
+// be done before r is used otherwise.
+func (xr *xmlReader) release() {
+	xr.r.Discard(xr.n)
+	xr.win, xr.n = nil, 0
+}
+
+// newline returns the line feed read for a carriage return of size
+// bytes, alone or followed by a line feed, and their size.
+func (xr *xmlReader) newline(size int) (rune, int) {
+	xr.release()
+	if b, _ := xr.r.Peek(1); len(b) == 1 && b[0] == '\n' {
+		xr.r.Discard(1)
+		size++
+		if xr.at.wide {
+			size++ // '\n' takes 2 bytes in UTF-16
+		}
+	}
+	return '\n', size
+}
+
+// UnreadRune unreads the last rune.
+func (xr *xmlReader) UnreadRune() error {
+	if xr.unread || xr.size == 0 {
+		return bufio.ErrInvalidUnreadRune
+	}
+	xr.unread = true
+	return nil
+}
+
+// declare checks the XML declaration raw, which starts at input
+// position start, and switches to the encoding it declares.
+func (xr *xmlReader) declare(raw string, start Pos) error {
+	decl, err := parseXmlDecl(raw, start)
+	if err != nil {
+		return err
+	}
+
+	if msg := xr.charset(decl.Encoding); msg != "" {
+		e := &SyntaxError{Pos: start, State: "decl", Found: decl.Encoding, Msg: msg}
+		if msg == "unsupported encoding" {
+			e.Expected = "UTF-8, UTF-16, ISO-8859-1 or US-ASCII"
+		}
+		return e
+	}
+	return nil
+}
+
+// procInst classifies the processing instruction raw, which starts
+// at input position start, as XML_HEAD or XML_PI. The XML declaration
+// is only accepted as the first markup of the document; in strict mode
+// nothing but a byte order mark may come before it.
+func (xr *xmlReader) procInst(raw string, start Pos, first bool) (int, error) {
+	target, _ := splitPI(raw)
+	switch {
+	case target == "":
+		return XML_PI, &SyntaxError{Pos: start.advance("<?"), State: "pi",
+			Found: raw, Expected: "a target name"}
+	case target == "xml" && first && (!xr.opt.strict || start.Line == 1 && start.Col == 1):
+		return XML_HEAD, xr.declare(raw, start.advance("<?"))
+	case strings.EqualFold(target, "xml"):
+		e := &SyntaxError{Pos: start, State: "pi", Found: "<?" + target}
+		if target == "xml" {
+			e.Msg = "XML declaration not at the start of the document"
+		} else {
+			e.Msg = "reserved processing instruction target"
+		}
+		return XML_PI, e
+	}
+	return XML_PI, nil
+}
+
+// Offset returns the number of bytes consumed so far.
+func (xr *xmlReader) Offset() int64 {
+	return xr.Pos().Offset
+}
+
+// Pos returns the position of the next rune.
+func (xr *xmlReader) Pos() Pos {
+	if xr.unread {
+		return xr.at
+	}
+	return xr.at.next(xr.last, xr.size)
+}
+
+// lastPos returns the position of the last rune read.
+func (xr *xmlReader) lastPos() Pos {
+	return xr.at
+}
+
+// syntaxError returns the error for the last rune read, or for the
+// end of input, being unexpected in scanner state state.
+func (xr *xmlReader) syntaxError(state string) *SyntaxError {
+	e := &SyntaxError{State: state, Expected: stateExpects[state]}
+	before := xr.line()
+	if xr.eof {
+		e.Pos = xr.Pos()
+	} else {
+		e.Pos = xr.at
+		e.Found = string(xr.last)
+		before = before[:len(before)-len(e.Found)]
+	}
+	before = strings.TrimSuffix(before, "\n")
+	xr.release()
+	ahead, _ := xr.r.Peek(excerptLen)
+	e.Excerpt = caretExcerpt(before, e.Found+string(ahead))
+	return e
+}
@@ -0,0 +1,110 @@
+package xmlparser
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SpacePolicy is the handling of white space in XML_TEXT tokens.
+type SpacePolicy int
+
+const (
+	// SpaceDefault is SpaceDrop, except that the tabs and line breaks
+	// leading the text after a start tag or CDATA section are dropped
+	// too. It is what the scanners did before the policies existed.
+	SpaceDefault SpacePolicy = iota
+
+	SpaceDrop     // drop white space only text, keep other text as is
+	SpacePreserve // keep all text as is
+	SpaceTrim     // drop white space only text, trim other text
+	SpaceCollapse // like SpaceTrim, and replace inner runs of white space with a space
+)
+
+// textSpace returns a scanner which applies the space policy of the
+// options of xr to the text tokens of scan. Text in an element with
+// xml:space="preserve" is kept as is, up to a descendant with
+// xml:space="default". xr.dropSpace tells scan which white space only
+// text would be dropped, so that it need not return it.
+func textSpace(scan XmlScanner, xr *xmlReader) XmlScanner {
+	opt := xr.opt
+	if opt.space == SpacePreserve {
+		return scan
+	}
+	xr.dropSpace = spaceChars
+
+	var preserve []bool // xml:space="preserve" is in effect in the open elements
+	var spaceKey bool   // the last property is xml:space
+	var afterStart bool // the last token ends a start tag or is CDATA
+	return XmlScanner(func() (XmlToken, error) {
+		for {
+			tk, err := scan()
+			if err != nil {
+				return tk, err
+			}
+
+			n := len(preserve)
+			switch tk.ID {
+			case XML_TAG_OPTN:
+				preserve = append(preserve, n > 0 && preserve[n-1])
+			case XML_PRO_KEY:
+				spaceKey = tk.Val == "xml:space"
+			case XML_PRO_VAL:
+				if spaceKey && n > 0 {
+					switch tk.Val {
+					case "preserve":
+						preserve[n-1] = true
+					case "default":
+						preserve[n-1] = false
+					}
+				}
+				spaceKey = false
+			case XML_TAG_CLOSE:
+				if n > 0 {
+					preserve = preserve[:n-1]
+				}
+			case XML_TEXT:
+				if n > 0 && preserve[n-1] {
+					break
+				}
+				if tk.Val = applySpace(opt.space, tk.Val, afterStart); tk.Val == "" {
+					continue
+				}
+			}
+			afterStart = tk.ID == XML_TAG_OPTN || tk.ID == XML_PRO_VAL || tk.ID == XML_CDATA
+			switch {
+			case len(preserve) > 0 && preserve[len(preserve)-1]:
+				xr.dropSpace = ""
+			case opt.space == SpaceDefault && afterStart:
+				xr.dropSpace = spaceChars[1:]
+			default:
+				xr.dropSpace = spaceChars
+			}
+			return tk, nil
+		}
+	})
+}
+
+// spaceChars are the characters of the S production, a space first.
+const spaceChars = " \t\n\r"
+
+// dropped reports whether textSpace drops the white space only text b.
+func (xr *xmlReader) dropped(b []byte) bool {
+	return xr.dropSpace != "" && len(bytes.Trim(b, xr.dropSpace)) == 0
+}
+
+// applySpace returns the text s under policy p, "" if s is dropped.
+// afterStart tells that s follows a start tag or CDATA section.
+func applySpace(p SpacePolicy, s string, afterStart bool) string {
+	trimmed := strings.TrimFunc(s, isSpace)
+	switch {
+	case p == SpaceDefault && afterStart:
+		return strings.TrimLeft(s, "\t\n\r")
+	case trimmed == "":
+		return ""
+	case p == SpaceTrim:
+		return trimmed
+	case p == SpaceCollapse:
+		return strings.Join(strings.FieldsFunc(trimmed, isSpace), " ")
+	}
+	return s
+}
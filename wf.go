@@ -0,0 +1,97 @@
+package xmlparser
+
+import "io"
+
+// wfChecker checks the document-level well-formedness constraints which
+// the scanners cannot see token by token.
+type wfChecker struct {
+	open  []XmlToken      // start tags of the open elements
+	root  bool            // the root element has been seen
+	attrs map[string]bool // property names of the current start tag
+	names map[QName]bool  // namespaced property names of the current start tag
+	end   Pos             // end of the last token
+	err   error
+}
+
+// checkWellFormed returns a scanner which passes the tokens of scan
+// through and fails with a *SyntaxError on more than one or no root
+// element, duplicate properties, text outside the root element and
+// elements left open at the end of the input.
+func checkWellFormed(scan NsScanner) NsScanner {
+	wf := &wfChecker{
+		attrs: make(map[string]bool),
+		names: make(map[QName]bool),
+		end:   Pos{Line: 1, Col: 1},
+	}
+	return NsScanner(func() (NsToken, error) {
+		if wf.err != nil {
+			return NsToken{}, wf.err
+		}
+		tk, err := scan()
+		if err == io.EOF {
+			err = wf.eof()
+		} else if err == nil {
+			err = wf.token(tk)
+		}
+		if err != nil && err != io.EOF {
+			wf.err = err
+		}
+		return tk, err
+	})
+}
+
+func (wf *wfChecker) token(tk NsToken) error {
+	wf.end = tk.End
+	switch tk.ID {
+	case XML_TAG_OPTN:
+		if len(wf.open) == 0 && wf.root {
+			return &SyntaxError{Pos: tk.Start, Found: "<" + tk.Val,
+				Msg: "more than one root element"}
+		}
+		wf.root = true
+		wf.open = append(wf.open, tk.XmlToken)
+		for k := range wf.attrs {
+			delete(wf.attrs, k)
+		}
+		for k := range wf.names {
+			delete(wf.names, k)
+		}
+
+	case XML_PRO_KEY:
+		dup := wf.attrs[tk.Val]
+		if tk.Name.Space != "" {
+			qn := QName{Space: tk.Name.Space, Local: tk.Name.Local}
+			dup = dup || wf.names[qn]
+			wf.names[qn] = true
+		}
+		if dup {
+			return &SyntaxError{Pos: tk.Start, Found: tk.Val, Msg: "duplicate property"}
+		}
+		wf.attrs[tk.Val] = true
+
+	case XML_TAG_CLOSE:
+		if len(wf.open) > 0 {
+			wf.open = wf.open[:len(wf.open)-1]
+		}
+
+	case XML_TEXT, XML_CDATA:
+		if len(wf.open) == 0 {
+			return &SyntaxError{Pos: tk.Start, Found: tk.Val,
+				Msg: "text outside the root element"}
+		}
+	}
+	return nil
+}
+
+// eof returns the error for the end of the input, io.EOF if the
+// document is complete.
+func (wf *wfChecker) eof() error {
+	if n := len(wf.open); n > 0 {
+		tk := wf.open[n-1]
+		return &SyntaxError{Pos: tk.Start, Msg: "unclosed element <" + tk.Val + ">"}
+	}
+	if !wf.root {
+		return &SyntaxError{Pos: wf.end, Msg: "missing root element"}
+	}
+	return io.EOF
+}
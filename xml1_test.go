@@ -1,10 +1,14 @@
 package xmlparser
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
+	"unicode/utf16"
 )
 
 var xmlstr string = `
@@ -153,3 +157,1495 @@ func TestParseXml(t *testing.T) {
 	}
 	ShowXml(root, nil, -1)
 }
+
+var scanners = []struct {
+	name string
+	scan func(string, ...Option) XmlScanner
+}{
+	{"scanner1", scanXml},
+	{"scanner2", scanXml2},
+	{"scanner3", scanXml3},
+}
+
+// tokval is a token without its position.
+type tokval struct {
+	ID  int
+	Val string
+}
+
+func tokvals(tks []XmlToken) []tokval {
+	tvs := make([]tokval, len(tks))
+	for i, tk := range tks {
+		tvs[i] = tokval{tk.ID, tk.Val}
+	}
+	return tvs
+}
+
+func scanAll(s XmlScanner) ([]XmlToken, error) {
+	var tks []XmlToken
+	for {
+		tk, err := s()
+		if err == io.EOF {
+			return tks, nil
+		} else if err != nil {
+			return tks, err
+		}
+		tks = append(tks, tk)
+	}
+}
+
+func TestQuotedProperty(t *testing.T) {
+	xml := `<a p1="hello world" p2='say "hi"' p3="x>y" p4=v4></a>`
+	want := []tokval{
+		{XML_TAG_OPTN, "a"},
+		{XML_PRO_KEY, "p1"}, {XML_PRO_VAL, "hello world"},
+		{XML_PRO_KEY, "p2"}, {XML_PRO_VAL, `say "hi"`},
+		{XML_PRO_KEY, "p3"}, {XML_PRO_VAL, "x>y"},
+		{XML_PRO_KEY, "p4"}, {XML_PRO_VAL, "v4"},
+		{XML_TAG_CLOSE, "a"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+	}
+
+	for _, bad := range []string{`<a p1="v1>`, `<a p1='v1"></a>`, `<a p1="v1"p2="v2">`} {
+		for _, sc := range scanners {
+			if _, err := scanAll(sc.scan(bad)); err == nil {
+				t.Errorf("%s: %q: expected syntax error", sc.name, bad)
+			}
+		}
+	}
+
+	// '<' is not allowed in a quoted value
+	for _, sc := range scanners {
+		_, err := scanAll(sc.scan(`<a p="x<y"/>`))
+		var se *SyntaxError
+		if !errors.As(err, &se) || se.State != "pq" || se.Found != "<" ||
+			se.Pos != (Pos{Offset: 7, Line: 1, Col: 8}) {
+			t.Errorf("%s: got %v, want error for '<'", sc.name, err)
+		}
+	}
+}
+
+func TestEmptyElement(t *testing.T) {
+	xml := `<a><br/><book id=1/><img src=a/b.png alt="x/y" /><c></c></a>`
+	want := []tokval{
+		{XML_TAG_OPTN, "a"},
+		{XML_TAG_OPTN, "br"}, {XML_TAG_CLOSE, "br"},
+		{XML_TAG_OPTN, "book"}, {XML_PRO_KEY, "id"}, {XML_PRO_VAL, "1"},
+		{XML_TAG_CLOSE, "book"},
+		{XML_TAG_OPTN, "img"}, {XML_PRO_KEY, "src"}, {XML_PRO_VAL, "a/b.png"},
+		{XML_PRO_KEY, "alt"}, {XML_PRO_VAL, "x/y"}, {XML_TAG_CLOSE, "img"},
+		{XML_TAG_OPTN, "c"}, {XML_TAG_CLOSE, "c"},
+		{XML_TAG_CLOSE, "a"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := root.sube[0]
+	if len(a.sube) != 4 {
+		t.Fatalf("got %d children, want 4", len(a.sube))
+	}
+	for _, n := range a.sube {
+		if n.ntype != XN_Tag || len(n.sube) != 0 {
+			t.Errorf("%v: want childless tag", n.name)
+		}
+	}
+	if book := a.sube[1]; len(book.prop) != 1 || book.prop[0].value != "1" {
+		t.Errorf("book: bad properties %v", book.prop)
+	}
+}
+
+func TestCData(t *testing.T) {
+	xml := `<a><![CDATA[ a < b && c > d ]] ]]]></a>`
+	want := []tokval{
+		{XML_TAG_OPTN, "a"},
+		{XML_CDATA, " a < b && c > d ]] ]"},
+		{XML_TAG_CLOSE, "a"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+		for _, bad := range []string{`<a><![CDATA[x]></a>`, `<a><![CDAT[x]]></a>`} {
+			if _, err := scanAll(sc.scan(bad)); err == nil {
+				t.Errorf("%s: %q: expected syntax error", sc.name, bad)
+			}
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd := root.sube[0].sube[0]; cd.ntype != XN_CData || cd.name != want[1].Val {
+		t.Errorf("got %v %q, want CDATA node", cd.ntype, cd.name)
+	}
+}
+
+func TestEntityDecode(t *testing.T) {
+	xml := `<a p="&lt;&quot;x&quot;&gt;">a &amp; b &#20013;&#x6587; &apos;</a>`
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if tks[2].Val != `<"x">` || tks[3].Val != "a & b 中文 '" {
+			t.Errorf("%s: got %q %q", sc.name, tks[2].Val, tks[3].Val)
+		}
+
+		tks, err = scanAll(sc.scan(xml, DecodeEntities(false)))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if tks[2].Val != "&lt;&quot;x&quot;&gt;" || tks[3].Val != "a &amp; b &#20013;&#x6587; &apos;" {
+			t.Errorf("%s: raw: got %q %q", sc.name, tks[2].Val, tks[3].Val)
+		}
+	}
+
+	bad := map[string]string{
+		`<a>x &foo; y</a>`:   "(offset 5)",
+		`<a p='&#0;'></a>`:   "(offset 6)",
+		`<a>&#xZZ;</a>`:      "(offset 3)",
+		`<a p=x&amp></a>`:    "(offset 6)",
+		`<a>&#xD800;</a>`:    "(offset 3)",
+		`<a>1 &#-1; 2</a>`:   "(offset 5)",
+		`<b>&lt</b>`:         "(offset 3)",
+		`<a>中 &nbsp;</a>`:    "(offset 7)",
+		`<a p="&bad;"/>`:     "(offset 6)",
+		`<a p=&bad;/></a>`:   "(offset 5)",
+		`<a p="&#x110000;">`: "(offset 6)",
+	}
+	for xml, at := range bad {
+		for _, sc := range scanners {
+			_, err := scanAll(sc.scan(xml))
+			if err == nil || !strings.Contains(err.Error(), at) {
+				t.Errorf("%s: %q: got %v, want error %v", sc.name, xml, err, at)
+			}
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	root, err := ParseReader(iotest.OneByteReader(strings.NewReader(xmlstr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.sube) != 2 || root.sube[1].name != "books" {
+		t.Errorf("unexpected tree %v", root.sube)
+	}
+
+	// a syntax error far beyond the read buffer
+	var b strings.Builder
+	b.WriteString("<list>")
+	for b.Len() < 3*readBufSize {
+		b.WriteString("<item>中文</item>")
+	}
+	off := b.Len()
+	b.WriteString("<item p='1'?>")
+	_, err = ParseReader(iotest.HalfReader(strings.NewReader(b.String())))
+	if want := fmt.Sprintf("(offset %v)", off+len("<item p='1'")); err == nil ||
+		!strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want error %v", err, want)
+	}
+
+	_, err = ParseReader(iotest.TimeoutReader(strings.NewReader(xmlstr)))
+	if err != iotest.ErrTimeout {
+		t.Errorf("got %v, want %v", err, iotest.ErrTimeout)
+	}
+}
+
+func TestTokenPos(t *testing.T) {
+	xml := "<书 名=\"中文\">\n\t<b>大道</b><a/></书>"
+	want := []string{
+		"0 1:1 - 4 1:3",     // <书
+		"5 1:4 - 8 1:5",     // 名
+		"9 1:6 - 17 1:10",   // "中文"
+		"20 2:2 - 22 2:4",   // <b
+		"23 2:5 - 29 2:7",   // 大道
+		"29 2:7 - 33 2:11",  // </b>
+		"33 2:11 - 35 2:13", // <a
+		"35 2:13 - 37 2:15", // />
+		"37 2:15 - 43 2:19", // </书>
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if len(tks) != len(want) {
+			t.Errorf("%s: got %d tokens, want %d", sc.name, len(tks), len(want))
+			continue
+		}
+		for i, tk := range tks {
+			got := fmt.Sprintf("%v %v - %v %v", tk.Start.Offset, tk.Start, tk.End.Offset, tk.End)
+			if got != want[i] {
+				t.Errorf("%s: %q: got %v, want %v", sc.name, tk.Val, got, want[i])
+			}
+		}
+
+		_, err = scanAll(sc.scan("<a>\n<b p=\"1\"x>"))
+		if err == nil || !strings.Contains(err.Error(), "at 2:9 (offset 12)") {
+			t.Errorf("%s: got %v, want error at 2:9", sc.name, err)
+		}
+		_, err = scanAll(sc.scan("<a>\n\t<b>&bad;</b></a>"))
+		if err == nil || !strings.Contains(err.Error(), "at 2:5 (offset 8)") {
+			t.Errorf("%s: got %v, want error at 2:5", sc.name, err)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, sc := range scanners {
+		_, err := scanAll(sc.scan("<a>\n\t<b p='1'x>"))
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Fatalf("%s: got %v, want *SyntaxError", sc.name, err)
+		}
+		want := SyntaxError{
+			Pos:      Pos{Offset: 13, Line: 2, Col: 10},
+			State:    "pt3",
+			Found:    "x",
+			Expected: `whitespace, '>' or "/>"`,
+			Excerpt:  "\t<b p='1'x>\n\t        ^",
+		}
+		if *se != want {
+			t.Errorf("%s: got %#v, want %#v", sc.name, *se, want)
+		}
+
+		_, err = scanAll(sc.scan("<a p='1"))
+		if !errors.As(err, &se) || se.Found != "" || se.State != "pq" ||
+			se.Pos.Offset != 7 || se.Excerpt != "<a p='1\n       ^" {
+			t.Errorf("%s: got %#v, want unexpected EOF in pq", sc.name, err)
+		}
+
+		_, err = scanAll(sc.scan("<a>x\n y &bad; z</a>"))
+		if !errors.As(err, &se) || se.Found != "&bad;" || se.State != "ref" ||
+			se.Pos != (Pos{Offset: 8, Line: 2, Col: 4}) || se.Excerpt != " y &bad; z\n   ^" {
+			t.Errorf("%s: got %#v, want bad reference", sc.name, err)
+		}
+
+		// excerpts of long lines keep whole runes
+		_, err = scanAll(sc.scan("<a>\n" + strings.Repeat("中", 30) + "<b p='1'x>"))
+		if !errors.As(err, &se) || se.Pos != (Pos{Offset: 102, Line: 2, Col: 39}) ||
+			se.Excerpt != strings.Repeat("中", 10)+"<b p='1'x>\n"+strings.Repeat(" ", 18)+"^" {
+			t.Errorf("%s: got %#v, want excerpt of a long line", sc.name, err)
+		}
+
+		// the scanner stops at an error and keeps returning it
+		for _, xml := range []string{
+			"<a>&bogus;</a><b>ok</b>",
+			`<a p="&bogus;"/><b>ok</b>`,
+			`<?xml version="2.0"?><b>ok</b>`,
+			"<!DOCTYPE a [<!ENTITY>]><b>ok</b>",
+			"<a><b p></b><b>ok</b>",
+		} {
+			scan := sc.scan(xml)
+			_, err := scanAll(scan)
+			if err == nil {
+				t.Errorf("%s: %q: got no error", sc.name, xml)
+				continue
+			}
+			for i := 0; i < 2; i++ {
+				if tk, err2 := scan(); tk != (XmlToken{}) || err2 != err {
+					t.Errorf("%s: %q: got %v, %v after the error, want %v", sc.name, xml, tk, err2, err)
+				}
+			}
+		}
+	}
+
+	_, err := ParseXml("<a>\n<b></c></a>")
+	var se *SyntaxError
+	if !errors.As(err, &se) || se.Found != "</c>" || se.Expected != "</b>" ||
+		se.Pos != (Pos{Offset: 7, Line: 2, Col: 4}) {
+		t.Errorf("got %#v, want invalid close tag", err)
+	}
+}
+
+func TestMixedContent(t *testing.T) {
+	xml := "<p>Hello <b>world</b>, bye<!-- c --> now<br/>!\n\t<i>x</i>\n</p>"
+	want := []tokval{
+		{XML_TAG_OPTN, "p"},
+		{XML_TEXT, "Hello "},
+		{XML_TAG_OPTN, "b"}, {XML_TEXT, "world"}, {XML_TAG_CLOSE, "b"},
+		{XML_TEXT, ", bye"},
+		{XML_COMMENT, " c "},
+		{XML_TEXT, " now"},
+		{XML_TAG_OPTN, "br"}, {XML_TAG_CLOSE, "br"},
+		{XML_TEXT, "!\n\t"},
+		{XML_TAG_OPTN, "i"}, {XML_TEXT, "x"}, {XML_TAG_CLOSE, "i"},
+		{XML_TAG_CLOSE, "p"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+		if _, err := scanAll(sc.scan("<p></p> junk")); err == nil {
+			t.Errorf("%s: expected syntax error for text after the root", sc.name)
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range root.sube[0].sube {
+		got = append(got, n.name)
+	}
+	if fmt.Sprint(got) != fmt.Sprint([]string{"Hello ", "b", ", bye", " c ", " now", "br", "!\n\t", "i"}) {
+		t.Errorf("got children %q", got)
+	}
+}
+
+func TestXmlDecl(t *testing.T) {
+	root, err := ParseXml(xmlstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := root.Decl(); d == nil || *d != (XmlDecl{"1.0", "UTF-8", ""}) {
+		t.Errorf("got %+v", d)
+	}
+
+	root, err = ParseXml(`<?xml version = "1.1" encoding='ascii' standalone="yes" ?><a/>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := root.Decl(); d == nil || *d != (XmlDecl{"1.1", "ascii", "yes"}) {
+		t.Errorf("got %+v", d)
+	}
+	if root, err = ParseXml(`<a/>`); err != nil || root.Decl() != nil {
+		t.Errorf("got %v %v, want no declaration", root.Decl(), err)
+	}
+
+	bad := map[string]string{
+		`<?xml?><a/>`:                                                "version",
+		`<?xml encoding="UTF-8"?><a/>`:                               "version",
+		`<?xml version="2.0"?><a/>`:                                  "version 1.x",
+		`<?xml version="1.0" encoding="8bit"?><a/>`:                  "an encoding name",
+		`<?xml version="1.0" standalone="maybe"?><a/>`:               `"yes" or "no"`,
+		`<?xml version="1.0" foo="bar"?><a/>`:                        "encoding or standalone",
+		`<?xml version="1.0" standalone="no" encoding="UTF-8"?><a/>`: `"?>"`,
+		`<?xml version="1.0"encoding="UTF-8"?><a/>`:                  "white space",
+		`<?xml version="1.0" encoding="EBCDIC"?><a/>`:                "UTF-8, UTF-16, ISO-8859-1 or US-ASCII",
+	}
+	for xml, expected := range bad {
+		for _, sc := range scanners {
+			_, err := scanAll(sc.scan(xml))
+			var se *SyntaxError
+			if !errors.As(err, &se) || se.Expected != expected {
+				t.Errorf("%s: %q: got %v, want error expecting %v", sc.name, xml, err, expected)
+			}
+		}
+	}
+
+	latin1 := "<?xml version='1.0' encoding='ISO-8859-1'?><a p='\xe0'>caf\xe9</a>"
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(latin1))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if tks[3].Val != "à" || tks[4].Val != "café" || tks[5].Start.Offset != 56 {
+			t.Errorf("%s: got %v", sc.name, tks[3:])
+		}
+	}
+}
+
+func TestProcInst(t *testing.T) {
+	xml := "\n<?xml version='1.0'?>\n<?xml-stylesheet href=\"a.xsl\" type=\"text/xsl\"?>\n" +
+		"<a><?app do <this> ?? now?>text<?empty?></a>\n<?tail x?>"
+	want := []tokval{
+		{XML_HEAD, "xml version='1.0'"},
+		{XML_PI, `xml-stylesheet href="a.xsl" type="text/xsl"`},
+		{XML_TAG_OPTN, "a"},
+		{XML_PI, "app do <this> ?? now"},
+		{XML_TEXT, "text"},
+		{XML_PI, "empty"},
+		{XML_TAG_CLOSE, "a"},
+		{XML_PI, "tail x"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+
+		for _, bad := range []string{
+			"<!-- c --><?xml version='1.0'?><a/>",
+			"<?pi?><?xml version='1.0'?><a/>",
+			"<a><?xml version='1.0'?></a>",
+			"<a><?XML x?></a>",
+			"<a><? x?></a>",
+			"<a><?pi x",
+		} {
+			if _, err := scanAll(sc.scan(bad)); err == nil {
+				t.Errorf("%s: %q: expected syntax error", sc.name, bad)
+			}
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.sube) != 4 || root.Decl() == nil {
+		t.Fatalf("got %d top level nodes", len(root.sube))
+	}
+	if pi := root.sube[1]; pi.ntype != XN_PI || pi.name != "xml-stylesheet" ||
+		pi.value != `href="a.xsl" type="text/xsl"` {
+		t.Errorf("got %v %q %q", pi.ntype, pi.name, pi.value)
+	}
+	if pi := root.sube[2].sube[0]; pi.ntype != XN_PI || pi.name != "app" || pi.value != "do <this> ?? now" {
+		t.Errorf("got %v %q %q", pi.ntype, pi.name, pi.value)
+	}
+	if pi := root.sube[3]; pi.ntype != XN_PI || pi.name != "tail" {
+		t.Errorf("got %v %q", pi.ntype, pi.name)
+	}
+
+	// in strict mode, only a byte order mark may come before the declaration
+	if _, err := ParseXml("  <?xml version=\"1.0\"?><a/>", Strict(true)); err == nil {
+		t.Errorf("declaration after white space: expected syntax error")
+	}
+	if root, err := ParseXml("\uFEFF<?xml version=\"1.0\"?><a/>", Strict(true)); err != nil || root.Decl() == nil {
+		t.Errorf("declaration after BOM: got %v", err)
+	}
+}
+
+func TestDoctype(t *testing.T) {
+	xml := `<?xml version="1.0"?>
+<!DOCTYPE books PUBLIC "-//ACME//Books" "books.dtd" [
+	<!-- don't [ stop ] here > -->
+	<!ELEMENT books (book*)>
+	<!ELEMENT book (#PCDATA|b)*>
+	<!ELEMENT br EMPTY>
+	<!ATTLIST book id ID #REQUIRED
+		lang (en|zh) "en"
+		ver CDATA #FIXED '1'
+		fmt NOTATION (gif) #IMPLIED>
+	<!ENTITY pub "ACME > [Inc]">
+	<!ENTITY % ext SYSTEM "ext.ent">
+	<!ENTITY logo SYSTEM "logo.gif" NDATA gif>
+	<!NOTATION gif PUBLIC "image/gif">
+	<?app ]> ?>
+	%ext;
+]>
+<books/>`
+	want := []tokval{
+		{XML_HEAD, `xml version="1.0"`},
+		{XML_DOCTYPE, xml[len(`<?xml version="1.0"?>`)+len("\n<!DOCTYPE") : len(xml)-len(">\n<books/>")]},
+		{XML_TAG_OPTN, "books"},
+		{XML_TAG_CLOSE, "books"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := root.Doctype()
+	if dt == nil || dt.Name != "books" || dt.PublicID != "-//ACME//Books" || dt.SystemID != "books.dtd" {
+		t.Fatalf("got %+v", dt)
+	}
+	if len(dt.Elements) != 3 || dt.Elements[1].Content != "(#PCDATA|b)*" || dt.Elements[2].Content != "EMPTY" {
+		t.Errorf("got elements %+v", dt.Elements)
+	}
+	if len(dt.Attlists) != 1 || len(dt.Attlists[0].Attrs) != 4 {
+		t.Fatalf("got attlists %+v", dt.Attlists)
+	}
+	for i, w := range []AttrDecl{
+		{"id", "ID", "#REQUIRED", ""},
+		{"lang", "(en|zh)", "", "en"},
+		{"ver", "CDATA", "#FIXED", "1"},
+		{"fmt", "NOTATION (gif)", "#IMPLIED", ""},
+	} {
+		if *dt.Attlists[0].Attrs[i] != w {
+			t.Errorf("got %+v, want %+v", *dt.Attlists[0].Attrs[i], w)
+		}
+	}
+	if len(dt.Entities) != 3 || dt.Entity("pub").Value != "ACME > [Inc]" ||
+		dt.Entity("ext") != nil || dt.Entity("logo").NData != "gif" {
+		t.Errorf("got entities %+v", dt.Entities)
+	}
+	if len(dt.Notations) != 1 || dt.Notations[0].PublicID != "image/gif" {
+		t.Errorf("got notations %+v", dt.Notations)
+	}
+
+	if root, err = ParseXml(`<!DOCTYPE a SYSTEM "a.dtd"><a/>`); err != nil {
+		t.Fatal(err)
+	} else if dt := root.Doctype(); dt.Name != "a" || dt.SystemID != "a.dtd" || dt.Subset != "" {
+		t.Errorf("got %+v", dt)
+	}
+
+	for _, bad := range []string{
+		`<!DOCTYP a><a/>`,
+		`<!DOCTYPE a [ <!ELEMENT a> ]><a/>`,
+		`<!DOCTYPE a [ <!ENTITY x> ]><a/>`,
+		`<!DOCTYPE a [ <!ATTLIST a b FOO #IMPLIED> ]><a/>`,
+		`<!DOCTYPE a [ <!BOGUS> ]><a/>`,
+		`<!DOCTYPE a SYSTEM><a/>`,
+		`<!DOCTYPE a [ <!ENTITY x "y"> `,
+		`<a><!DOCTYPE a></a>`,
+		`<!DOCTYPE a><!DOCTYPE a><a/>`,
+	} {
+		if _, err := ParseXml(bad); err == nil {
+			t.Errorf("%q: expected syntax error", bad)
+		}
+	}
+}
+
+func TestEntityExpand(t *testing.T) {
+	xml := `<!DOCTYPE a [
+	<!ENTITY pub "ACME &amp; Co">
+	<!ENTITY who "&pub; &#x4e2d;">
+	<!ENTITY % pe "param">
+]>
+<a p="by &who;">&who;, &pub;</a>`
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		var vals []string
+		for _, tk := range tks[1:] {
+			vals = append(vals, tk.Val)
+		}
+		want := "a|p|by ACME & Co 中|ACME & Co 中, ACME & Co|a"
+		if got := strings.Join(vals, "|"); got != want {
+			t.Errorf("%s: got %q, want %q", sc.name, got, want)
+		}
+	}
+
+	laughs := `<!DOCTYPE lolz [
+	<!ENTITY lol "lol">
+	<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+	<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+	<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+	<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+	<!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;">
+	<!ENTITY lol6 "&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;">
+	<!ENTITY lol7 "&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;">
+	<!ENTITY lol8 "&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;">
+	<!ENTITY lol9 "&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;">
+	<!ENTITY self "x &self;">
+	<!ENTITY ext SYSTEM "ext.xml">
+]>
+`
+	bad := []struct {
+		xml  string
+		opts []Option
+		want string
+	}{
+		{laughs + `<lolz>&lol9;</lolz>`, nil, "entity expansion exceeds 1048576 bytes"},
+		{laughs + `<lolz>&lol3;</lolz>`, []Option{EntityLimits(16, 1000)}, "exceeds 1000 bytes"},
+		{laughs + `<lolz>&lol3;</lolz>`, []Option{EntityLimits(3, 1<<20)}, "nesting exceeds 3 levels"},
+		{laughs + `<lolz p='&self;'/>`, nil, "recursive entity reference"},
+		{laughs + `<lolz>&ext;</lolz>`, nil, "external entity is not supported"},
+		{laughs + `<lolz>&nope;</lolz>`, nil, "expected a declared entity"},
+	}
+	for _, c := range bad {
+		for _, sc := range scanners {
+			start := time.Now()
+			_, err := scanAll(sc.scan(c.xml, c.opts...))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("%s: got %v, want error %q", sc.name, err, c.want)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("%s: took %v to fail", sc.name, d)
+			}
+		}
+	}
+
+	// replacement text is not parsed as markup, so '<' in it is an error
+	markup := `<!DOCTYPE a [<!ENTITY e "<b>x</b>"><!ENTITY f "see &e;">]>` + "\n"
+	for _, c := range []struct{ xml, want string }{
+		{markup + `<a>&e;</a>`,
+			`at 2:4 (offset 62), markup in replacement text of &e; is not supported, found "&e;"`},
+		{markup + `<a p="x &f;"/>`,
+			`at 2:9 (offset 67), '<' in replacement text of &e; in a property value, found "&f;"`},
+	} {
+		for _, sc := range scanners {
+			_, err := scanAll(sc.scan(c.xml))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("%s: got %v, want error %q", sc.name, err, c.want)
+			}
+		}
+	}
+
+	// many references take linear time, and errors are still positioned
+	many := `<!DOCTYPE a [<!ENTITY x "x"><!ENTITY e "<b/>">]>` + "\n<a>" +
+		strings.Repeat("&x;", 80000) + "\n&e;</a>"
+	for _, sc := range scanners {
+		start := time.Now()
+		_, err := scanAll(sc.scan(many))
+		want := "at 3:1 (offset 240053), markup in replacement text"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error %q", sc.name, err, want)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: took %v for 80000 references", sc.name, d)
+		}
+	}
+
+	// lol3 expands to 3000 bytes under the default limits
+	root, err := ParseXml(laughs + `<lolz>&lol3;</lolz>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(root.sube[1].sube[0].name); n != 3000 {
+		t.Errorf("got %d bytes of text, want 3000", n)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	xml := `<soap:Envelope xmlns:soap="urn:soap" xmlns="urn:d" soap:ver="1.2" id="e">
+<soap:Body><item xml:lang="en" xmlns=""><m:x xmlns:m="urn:m"/></item><d/></soap:Body></soap:Envelope>`
+	want := []string{
+		"soap:Envelope {urn:soap}Envelope",
+		"xmlns:soap {http://www.w3.org/2000/xmlns/}soap",
+		"xmlns {http://www.w3.org/2000/xmlns/}xmlns",
+		"soap:ver {urn:soap}ver",
+		"id {}id",
+		"soap:Body {urn:soap}Body",
+		"item {}item",
+		"xml:lang {http://www.w3.org/XML/1998/namespace}lang",
+		"xmlns {http://www.w3.org/2000/xmlns/}xmlns",
+		"m:x {urn:m}x",
+		"xmlns:m {http://www.w3.org/2000/xmlns/}m",
+		"m:x {urn:m}x",
+		"item {}item",
+		"d {urn:d}d",
+		"d {urn:d}d",
+		"soap:Body {urn:soap}Body",
+		"soap:Envelope {urn:soap}Envelope",
+	}
+	for _, sc := range scanners {
+		scan := NewNsScanner(sc.scan(xml))
+		var got []string
+		for {
+			tk, err := scan()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", sc.name, err)
+			}
+			switch tk.ID {
+			case XML_TAG_OPTN, XML_TAG_CLOSE, XML_PRO_KEY:
+				got = append(got, fmt.Sprintf("%v {%v}%v", tk.Name, tk.Name.Space, tk.Name.Local))
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: got\n%v", sc.name, strings.Join(got, "\n"))
+		}
+	}
+
+	root, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := root.sube[0]
+	if qn := env.QName(); qn.Space != "urn:soap" || qn.Prefix != "soap" || qn.Local != "Envelope" {
+		t.Errorf("got %+v", qn)
+	}
+	if qn := env.prop[2].QName(); qn.Space != "urn:soap" || qn.Local != "ver" {
+		t.Errorf("got %+v", qn)
+	}
+
+	bad := map[string]string{
+		`<p:a></p:a>`:                           "at 1:1 (offset 0), undeclared namespace prefix p",
+		`<a p:x="1"/>`:                          "at 1:4 (offset 3), undeclared namespace prefix p",
+		`<a xmlns:p="urn:p"/><p:b/>`:            "undeclared namespace prefix p",
+		`<a xmlns:p=""/>`:                       "empty namespace for prefix p",
+		`<a xmlns:xml="urn:x"/>`:                "the xml prefix cannot be bound",
+		`<a xmlns:xmlns="urn:x"/>`:              "the xmlns prefix cannot be declared",
+		`<a xmlns:x="` + XmlnsNamespace + `"/>`: "is reserved",
+		`<a:b:c xmlns:a="urn:a"/>`:              "invalid qualified name",
+		`<a :b="1"/>`:                           "invalid qualified name",
+	}
+	for xml, want := range bad {
+		_, err := ParseXml(xml)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", xml, err, want)
+		}
+	}
+}
+
+func TestTagWhitespace(t *testing.T) {
+	xml := "<book\n  id=1\tlang=en\r\n><a id = \"1\"  b\t=\n'2' /><c\tx=y\n/></book\n>"
+	want := []tokval{
+		{XML_TAG_OPTN, "book"},
+		{XML_PRO_KEY, "id"}, {XML_PRO_VAL, "1"},
+		{XML_PRO_KEY, "lang"}, {XML_PRO_VAL, "en"},
+		{XML_TAG_OPTN, "a"},
+		{XML_PRO_KEY, "id"}, {XML_PRO_VAL, "1"},
+		{XML_PRO_KEY, "b"}, {XML_PRO_VAL, "2"},
+		{XML_TAG_CLOSE, "a"},
+		{XML_TAG_OPTN, "c"}, {XML_PRO_KEY, "x"}, {XML_PRO_VAL, "y"},
+		{XML_TAG_CLOSE, "c"},
+		{XML_TAG_CLOSE, "book"},
+	}
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		if fmt.Sprint(tokvals(tks)) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", sc.name, tokvals(tks), want)
+			continue
+		}
+		// the quoted value starts at its quote after " = "
+		if got := tks[7].Start.String(); got != "3:10" {
+			t.Errorf("%s: value at %v, want 3:10", sc.name, got)
+		}
+
+		bad := map[string]string{
+			"<a id x=1></a>": "at 1:7 (offset 6), found \"x\", expected '='",
+			"<a></a b>":      "at 1:8 (offset 7), found \"b\", expected '>'",
+		}
+		for xml, want := range bad {
+			_, err := scanAll(sc.scan(xml))
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %q: got %v, want %v", sc.name, xml, err, want)
+			}
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	xml := `<书名 _a.b-c="1" ns:x·y="2"><Ω_1/></书名>`
+	for _, sc := range scanners {
+		if _, err := scanAll(sc.scan(xml)); err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+		}
+
+		bad := map[string]string{
+			`<1 2 3>`:          `at 1:2 (offset 1), found "1", expected a name`,
+			`<a"b>`:            `at 1:3 (offset 2), found "\"", expected a name character`,
+			`<a 2b="1"/>`:      `at 1:4 (offset 3), found "2", expected a property name`,
+			`<a b="1" =c/>`:    `at 1:10 (offset 9), found "=", expected a property name`,
+			`<a b>`:            `at 1:5 (offset 4), found ">", expected a property name`,
+			`<a x%y="1"/>`:     `at 1:5 (offset 4), found "%"`,
+			`<a></-a>`:         `at 1:6 (offset 5), found "-", expected a name character`,
+			`<a></>`:           `at 1:6 (offset 5), found ">"`,
+			`<a></a&>`:         `at 1:7 (offset 6), found "&"`,
+			`<>`:               `at 1:2 (offset 1), found ">"`,
+			"<a>\n\t< b/></a>": `at 2:3 (offset 6), found " "`,
+		}
+		for xml, want := range bad {
+			_, err := scanAll(sc.scan(xml))
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %q: got %v, want %v", sc.name, xml, err, want)
+			}
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	// the XML declaration of the fixture comes after a newline
+	_, err := ParseXml(xmlstr, Strict(true))
+	if err == nil || !strings.Contains(err.Error(), `at 2:1 (offset 1), XML declaration not at the start of the document`) {
+		t.Errorf("got %v, want error for the declaration", err)
+	}
+
+	// and its comment contains "--"
+	doc := strings.TrimPrefix(xmlstr, "\n")
+	_, err = ParseXml(doc, Strict(true))
+	if err == nil || !strings.Contains(err.Error(), `at 3:25 (offset 68), found " ", expected '>' after "--"`) {
+		t.Errorf("got %v, want error for \"--\"", err)
+	}
+	if _, err := ParseXml(strings.Replace(doc, "-x- --", "-x-", 1), Strict(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := map[string]string{
+		`<a><b>`:                  "at 1:4 (offset 3), unclosed element <b>, unexpected EOF",
+		"<a/>\n<b/>":              `at 2:1 (offset 5), more than one root element, found "<b"`,
+		"<?xml version='1.0'?>\n": "at 1:22 (offset 21), missing root element",
+		``:                        "at 1:1 (offset 0), missing root element",
+		`<a x="1" y="2" x="3"/>`:  `at 1:16 (offset 15), duplicate property, found "x"`,
+		`<a xmlns:p="u" xmlns:q="u" p:x="1" q:x="2"/>`: `duplicate property, found "q:x"`,
+		`<a/><![CDATA[x]]>`:                            "at 1:5 (offset 4), text outside the root element",
+	}
+	for xml, want := range bad {
+		_, err := ParseXml(xml, Strict(true))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %v", xml, err, want)
+		}
+		if _, err := ParseXml(xml); err != nil && xml != `<a><b>` {
+			t.Errorf("%q: not strict: %v", xml, err)
+		}
+	}
+
+	// truncated markup is an error in any mode
+	for _, xml := range []string{`<a><b`, `<a x="1"`, `<a x=`, `<a/><!--`, `<a></a`, `<a><![CD`} {
+		for _, sc := range scanners {
+			_, err := scanAll(sc.scan(xml))
+			if err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+				t.Errorf("%s: %q: got %v, want unexpected EOF", sc.name, xml, err)
+			}
+		}
+	}
+}
+
+// utf16Bytes encodes s as UTF-16 with a byte order mark if bom is set.
+func utf16Bytes(s string, bigEndian, bom bool) string {
+	var b []byte
+	put := func(u uint16) {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	if bom {
+		put(0xFEFF)
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		put(u)
+	}
+	return string(b)
+}
+
+func TestCharset(t *testing.T) {
+	xml := `<?xml version="1.0" encoding="UTF-16"?><书 名="中文">𝄞 x</书>`
+	inputs := map[string]string{
+		"utf-8 bom": "\xEF\xBB\xBF" + `<?xml version="1.0"?><书 名="中文">𝄞 x</书>`,
+		"utf-16be":  utf16Bytes(xml, true, true),
+		"utf-16le":  utf16Bytes(xml, false, true),
+		"no bom be": utf16Bytes(xml, true, false),
+		"no bom le": utf16Bytes(xml, false, false),
+		"cp1252":    "<?xml version='1.0' encoding='cp1252'?><\xe9 \x80='\xe9'>\x80</\xe9>",
+	}
+	cp1252 := func(charset string, input io.Reader) (io.Reader, error) {
+		if !strings.EqualFold(charset, "cp1252") {
+			return nil, fmt.Errorf("unknown charset %s", charset)
+		}
+		b, err := io.ReadAll(input)
+		var s strings.Builder
+		for _, c := range b {
+			if c == 0x80 {
+				s.WriteRune('€')
+			} else {
+				s.WriteRune(rune(c))
+			}
+		}
+		return strings.NewReader(s.String()), err
+	}
+	for name, in := range inputs {
+		for _, sc := range scanners {
+			tks, err := scanAll(sc.scan(in, CharsetReader(cp1252)))
+			if err != nil {
+				t.Errorf("%s: %s: %v", sc.name, name, err)
+				continue
+			}
+			var vals []string
+			for _, tk := range tks[1:] {
+				vals = append(vals, tk.Val)
+			}
+			want := "书|名|中文|𝄞 x|书"
+			if name == "cp1252" {
+				want = "é|€|é|€|é"
+			}
+			if got := strings.Join(vals, "|"); got != want {
+				t.Errorf("%s: %s: got %q, want %q", sc.name, name, got, want)
+			}
+		}
+	}
+
+	// offsets count the byte order mark
+	tks, _ := scanAll(scanXml("\xEF\xBB\xBF<a/>"))
+	if tks[0].Start.Offset != 3 || tks[0].Start.Col != 1 {
+		t.Errorf("got %v offset %d, want 1:1 offset 3", tks[0].Start, tks[0].Start.Offset)
+	}
+
+	// and the bytes of UTF-16 input
+	_, err := ParseXml(utf16Bytes("<a>中 x</b></a>", false, true))
+	if err == nil || !strings.Contains(err.Error(), "at 1:7 (offset 14)") {
+		t.Errorf("got %v, want error at offset 14", err)
+	}
+	in := utf16Bytes("<a p='&#x1D11E;'>𝄞\n&bad;</a>", true, true)
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(in))
+		var se *SyntaxError
+		if len(tks) != 3 || tks[2].Start.Offset != 12 || tks[2].End.Offset != 34 ||
+			!errors.As(err, &se) || se.Pos.Offset != 42 || se.Pos.Line != 2 {
+			t.Errorf("%s: got %v %v", sc.name, tks, err)
+		}
+	}
+
+	bad := map[string]string{
+		utf16Bytes(`<?xml version="1.0" encoding="ISO-8859-1"?><a/>`, true, true): "encoding differs from the UTF-16 input",
+		"\xEF\xBB\xBF<?xml version='1.0' encoding='US-ASCII'?><a/>":               "encoding differs from the UTF-8 byte order mark",
+		`<?xml version="1.0" encoding="cp1252"?><a/>`:                             "unsupported encoding",
+		utf16Bytes(`<a/>`, false, true)[:5]:                                       "unexpected EOF",
+	}
+	for in, want := range bad {
+		for _, sc := range scanners {
+			_, err := scanAll(sc.scan(in))
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %q: got %v, want %v", sc.name, in, err, want)
+			}
+		}
+	}
+	_, err = ParseXml(`<?xml version="1.0" encoding="koi8-r"?><a/>`, CharsetReader(cp1252))
+	if err == nil || !strings.Contains(err.Error(), "unknown charset koi8-r") {
+		t.Errorf("got %v, want unknown charset", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	xml := "<!DOCTYPE a [\r\n<!ATTLIST a ids IDREFS #IMPLIED>\r\n<!ENTITY sp \"x\ty\">]>\r\n" +
+		"<a p=\"1\r\n2\t3&#10;4&sp;\" ids=\"  x\r\n  y&#9; z \" q=a/b\r\n>l1\r\nl2\rl3<![CDATA[c\r\nd]]><!--m\r\nn--></a>"
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xml))
+		if err != nil {
+			t.Errorf("%s: %v", sc.name, err)
+			continue
+		}
+		var vals []string
+		for _, tk := range tks[2:] {
+			vals = append(vals, tk.Val)
+		}
+		want := "p|1 2 3\n4x y|ids|x y\t z|q|a/b|l1\nl2\nl3|c\nd|m\nn|a"
+		if got := strings.Join(vals, "|"); got != want {
+			t.Errorf("%s: got %q, want %q", sc.name, got, want)
+		}
+		// CRLF is one line break of two bytes
+		if end := tks[len(tks)-1].End; end.Line != 11 || end.Offset != int64(len(xml)) {
+			t.Errorf("%s: ends at %v offset %d", sc.name, end, end.Offset)
+		}
+
+		tks, err = scanAll(sc.scan(xml, Normalize(false)))
+		if err != nil {
+			t.Errorf("%s: raw: %v", sc.name, err)
+			continue
+		}
+		vals = vals[:0]
+		for _, tk := range tks[2:] {
+			vals = append(vals, tk.Val)
+		}
+		want = "p|1\r\n2\t3\n4x\ty|ids|  x\r\n  y\t z |q|a/b|l1\r\nl2\rl3|c\r\nd|m\r\nn|a"
+		if got := strings.Join(vals, "|"); got != want {
+			t.Errorf("%s: raw: got %q, want %q", sc.name, got, want)
+		}
+	}
+}
+
+func TestTextSpace(t *testing.T) {
+	xml := "<a>\n\t<name>\n\t\t 大  道\t</name>\n\t<pre xml:space=\"preserve\">\n <b>  x  </b> <c xml:space=\"default\"> y  z </c></pre>\n</a>"
+	want := map[SpacePolicy]string{
+		SpaceDefault:  "| 大  道\t|\n |  x  | | y  z ",
+		SpacePreserve: "|\n\t|\n\t\t 大  道\t|\n\t|\n |  x  | | y  z |\n",
+		SpaceDrop:     "|\n\t\t 大  道\t|\n |  x  | | y  z ",
+		SpaceTrim:     "|大  道|\n |  x  | |y  z",
+		SpaceCollapse: "|大 道|\n |  x  | |y z",
+	}
+	for p, want := range want {
+		for _, sc := range scanners {
+			tks, err := scanAll(sc.scan(xml, TextSpace(p)))
+			if err != nil {
+				t.Errorf("%s: %v", sc.name, err)
+				continue
+			}
+			var got string
+			for _, tk := range tks {
+				if tk.ID == XML_TEXT {
+					got += "|" + tk.Val
+				}
+			}
+			if got != want {
+				t.Errorf("%s: policy %d: got %q, want %q", sc.name, p, got, want)
+			}
+		}
+	}
+
+	// the default keeps the text of the fixture as the scanners always gave it
+	for _, sc := range scanners {
+		tks, err := scanAll(sc.scan(xmlstr))
+		if err != nil {
+			t.Fatalf("%s: %v", sc.name, err)
+		}
+		var got []string
+		for _, tk := range tks {
+			if tk.ID == XML_TEXT {
+				got = append(got, tk.Val)
+			}
+		}
+		want := []string{" 大 道 中 国 ", " 89.00 ", "张大中", "小猪唏哩呼噜", "22.50", "Alex"}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%s: got %q, want %q", sc.name, got, want)
+		}
+	}
+}
+
+func TestComment(t *testing.T) {
+	comments := map[string]string{
+		`<!-- a < b > c -->`:  " a < b > c ",
+		`<!-- - - -x- -- -->`: " - - -x- -- ",
+		`<!--a--->`:           "a-",
+		`<!---->`:             "",
+		"<!-- 中\r\n文 -->":     " 中\r\n文 ",
+	}
+	for xml, want := range comments {
+		for _, sc := range scanners {
+			tks, err := scanAll(sc.scan(xml+"<a/>", Normalize(false)))
+			if err != nil || tks[0].ID != XML_COMMENT || tks[0].Val != want {
+				t.Errorf("%s: %q: got %v %v, want %q", sc.name, xml, tks, err, want)
+			}
+
+			_, err = scanAll(sc.scan(xml+"<a/>", Strict(true)))
+			if strings.Contains(want, "--") || strings.HasSuffix(want, "-") {
+				if err == nil || !strings.Contains(err.Error(), `expected '>' after "--"`) {
+					t.Errorf("%s: %q: strict: got %v", sc.name, xml, err)
+				}
+			} else if err != nil {
+				t.Errorf("%s: %q: strict: %v", sc.name, xml, err)
+			}
+		}
+	}
+}
+
+func TestReadAPI(t *testing.T) {
+	root, err := ParseXml(`<?xml version="1.0"?>
+<book id="7" lang="en"><?render fast?><title>Go <b>in</b> a <![CDATA[<Action>]]></title><!-- c --><price>9</price></book>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Type() != XN_Dummy || root.Name() != "" {
+		t.Errorf("root: got %v %q", root.Type(), root.Name())
+	}
+	book := root.ChildElements()[0]
+	if book.Type() != XN_Tag || book.Name() != "book" {
+		t.Fatalf("got %v %q", book.Type(), book.Name())
+	}
+	if got := fmt.Sprint(book.Attrs()); got != "[{id 7} {lang en}]" {
+		t.Errorf("attrs: got %v", got)
+	}
+	if v, ok := book.Attr("lang"); !ok || v != "en" {
+		t.Errorf("lang: got %q %v", v, ok)
+	}
+	if _, ok := book.Attr("isbn"); ok {
+		t.Errorf("isbn: found")
+	}
+
+	kids := book.Children()
+	if len(kids) != 4 {
+		t.Fatalf("got %d children", len(kids))
+	}
+	if kids[0].Type() != XN_PI || kids[0].Name() != "render" || kids[0].Text() != "fast" {
+		t.Errorf("pi: got %v %q %q", kids[0].Type(), kids[0].Name(), kids[0].Text())
+	}
+	if kids[2].Type() != XN_Comment || kids[2].Name() != "" || kids[2].Text() != " c " {
+		t.Errorf("comment: got %v %q %q", kids[2].Type(), kids[2].Name(), kids[2].Text())
+	}
+	elems := book.ChildElements()
+	if len(elems) != 2 || elems[0].Name() != "title" || elems[1].Name() != "price" {
+		t.Fatalf("got elements %v", elems)
+	}
+	title := elems[0]
+	if got := title.Text(); got != "Go  a <Action>" {
+		t.Errorf("text: got %q", got)
+	}
+	if got := title.InnerText(); got != "Go in a <Action>" {
+		t.Errorf("inner text: got %q", got)
+	}
+	if got := book.InnerText(); got != "Go in a <Action>9" {
+		t.Errorf("book inner text: got %q", got)
+	}
+
+	kids[0] = nil
+	if book.Children()[0] == nil {
+		t.Errorf("Children exposes the node's slice")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	names := func(n *XmlNode) string {
+		var s []string
+		for _, c := range n.Children() {
+			if c.Type() == XN_Tag {
+				s = append(s, c.Name())
+			} else {
+				s = append(s, c.Text())
+			}
+		}
+		return strings.Join(s, ",")
+	}
+
+	root, err := ParseXml(`<list><a/><b/><c/></list>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := root.ChildElements()[0]
+	kids := list.Children()
+	a, b, c := kids[0], kids[1], kids[2]
+
+	d := NewElement("x:d")
+	if qn := d.QName(); qn.Prefix != "x" || qn.Local != "d" {
+		t.Errorf("qname: got %+v", qn)
+	}
+	d.SetAttr("k", "1")
+	d.SetAttr("k", "2")
+	d.SetAttr("j", "3")
+	if got := fmt.Sprint(d.Attrs()); got != "[{k 2} {j 3}]" {
+		t.Errorf("attrs: got %v", got)
+	}
+	if !d.RemoveAttr("k") || d.RemoveAttr("k") {
+		t.Errorf("RemoveAttr")
+	}
+	d.AppendChild(NewText("t"))
+
+	if err := list.InsertBefore(d, b); err != nil {
+		t.Fatal(err)
+	}
+	list.AppendChild(NewComment("note"))
+	if got := names(list); got != "a,x:d,b,c,note" {
+		t.Errorf("insert: got %v", got)
+	}
+	if err := list.InsertBefore(c, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(list); got != "c,a,x:d,b,note" {
+		t.Errorf("move: got %v", got)
+	}
+	if err := list.RemoveChild(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.RemoveChild(b); err != ErrNotChild {
+		t.Errorf("remove again: got %v", err)
+	}
+	if err := list.ReplaceWith(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(list); got != "c,b,x:d,note" {
+		t.Errorf("replace: got %v", got)
+	}
+	if err := list.ReplaceWith(a, b); err != ErrNotChild {
+		t.Errorf("replace removed: got %v", err)
+	}
+
+	cp := list.Clone()
+	if got := names(cp); got != "c,b,x:d,note" {
+		t.Errorf("clone: got %v", got)
+	}
+	if err := list.RemoveChild(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AppendChild(d); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(list)+"|"+names(cp), "c,b,note|c,b,x:d,note"; got != want {
+		t.Errorf("clone is not deep: got %v", got)
+	}
+	if d.InnerText() != "t" || b.InnerText() != "t" {
+		t.Errorf("inner text: got %q %q", d.InnerText(), b.InnerText())
+	}
+
+	for _, tc := range []struct {
+		parent, child, ref *XmlNode
+		err                error
+	}{
+		{list, list, nil, ErrHierarchy},
+		{d, list, nil, ErrHierarchy},
+		{d.Children()[0], NewText("u"), nil, ErrHierarchy},
+		{list, root, nil, ErrHierarchy},
+		{list, NewText("u"), a, ErrNotChild},
+		{list, nil, nil, ErrHierarchy},
+	} {
+		if err := tc.parent.InsertBefore(tc.child, tc.ref); err != tc.err {
+			t.Errorf("insert %v into %v: got %v, want %v", tc.child, tc.parent, err, tc.err)
+		}
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	root, err := ParseXml(`<catalog><book id="1"><price>9</price></book><!--x--><book id="2">a<price>7</price>b</book></catalog>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := root.FirstChild()
+	book2 := catalog.LastChild()
+	price := book2.ChildElements()[0]
+	if price.Parent() != book2 || book2.Parent() != catalog || catalog.Parent() != root || root.Parent() != nil {
+		t.Errorf("parents")
+	}
+	if d := price.Depth(); d != 3 {
+		t.Errorf("depth: got %d", d)
+	}
+	if price.PrevSibling().Text() != "a" || price.NextSibling().Text() != "b" ||
+		price.NextSibling().NextSibling() != nil || book2.FirstChild().PrevSibling() != nil {
+		t.Errorf("siblings")
+	}
+	if catalog.FirstChild().NextSibling().Type() != XN_Comment || price.FirstChild().FirstChild() != nil {
+		t.Errorf("first child")
+	}
+
+	id := book2.prop[0]
+	for _, tc := range []struct {
+		node *XmlNode
+		path string
+	}{
+		{root, "/"},
+		{catalog, "/catalog"},
+		{price, "/catalog/book[2]/price"},
+		{price.FirstChild(), "/catalog/book[2]/price/text()"},
+		{book2.FirstChild(), "/catalog/book[2]/text()[1]"},
+		{id, "/catalog/book[2]/@id"},
+		{catalog.sube[1], "/catalog/comment()"},
+	} {
+		if got := tc.node.Path(); got != tc.path {
+			t.Errorf("path: got %v, want %v", got, tc.path)
+		}
+	}
+	if id.Parent() != book2 || id.NextSibling() != nil {
+		t.Errorf("property parent")
+	}
+
+	// the mutation operations keep the parent pointers
+	book1 := catalog.FirstChild()
+	if err := book1.AppendChild(price); err != nil {
+		t.Fatal(err)
+	}
+	if price.Parent() != book1 || price.Path() != "/catalog/book[1]/price[2]" || book2.FirstChild().NextSibling().Text() != "b" {
+		t.Errorf("moved: got %v", price.Path())
+	}
+	cp := book1.Clone()
+	if cp.Parent() != nil || cp.LastChild().Parent() != cp || cp.prop[0].Parent() != cp || cp.LastChild().Path() != "/book/price[2]" {
+		t.Errorf("clone parents")
+	}
+	if err := catalog.RemoveChild(book1); err != nil || book1.Parent() != nil || book1.Depth() != 0 {
+		t.Errorf("removed: %v", err)
+	}
+	if err := book2.RemoveChild(id); err != ErrNotChild {
+		t.Errorf("remove property: got %v", err)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	const xml = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- before -->
+<!DOCTYPE note SYSTEM "note.dtd" [<!ENTITY who "you">]>
+<?style a?>
+<note to="&who;"><body>hi</body></note>
+<!-- after -->`
+	doc, err := ParseDocument(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Decl == nil || doc.Decl.Encoding != "UTF-8" {
+		t.Errorf("decl: got %+v", doc.Decl)
+	}
+	if doc.Doctype == nil || doc.Doctype.Name != "note" || doc.Doctype.SystemID != "note.dtd" {
+		t.Errorf("doctype: got %+v", doc.Doctype)
+	}
+	if len(doc.Prolog) != 2 || doc.Prolog[0].Text() != " before " || doc.Prolog[1].Name() != "style" {
+		t.Errorf("prolog: got %v", doc.Prolog)
+	}
+	if len(doc.Epilog) != 1 || doc.Epilog[0].Text() != " after " {
+		t.Errorf("epilog: got %v", doc.Epilog)
+	}
+	root := doc.Root()
+	if root.Name() != "note" || root.Parent() != nil || root.Depth() != 0 {
+		t.Fatalf("root: got %q %v", root.Name(), root.Parent())
+	}
+	if v, _ := root.Attr("to"); v != "you" {
+		t.Errorf("entity: got %q", v)
+	}
+	if got := root.FirstChild().Path(); got != "/note/body" {
+		t.Errorf("path: got %v", got)
+	}
+
+	var b strings.Builder
+	ShowDocument(doc, &b)
+	want := `<?xml version=1.0 encoding=UTF-8?>
+<!--  before  -->
+<!DOCTYPE note SYSTEM "note.dtd" [<!ENTITY who "you">]>
+<?style a?>
+<note to=you>
+	<body>
+		hi
+	</body>
+</note>
+<!--  after  -->
+`
+	if b.String() != want {
+		t.Errorf("ShowDocument: got\n%v", b.String())
+	}
+
+	body := root.FirstChild()
+	if err := doc.SetRoot(body); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Root() != body || body.Parent() != nil || root.FirstChild() != nil {
+		t.Errorf("SetRoot")
+	}
+	if _, err := NewDocument(NewText("x")); err != ErrHierarchy {
+		t.Errorf("NewDocument: got %v", err)
+	}
+
+	for _, tc := range []struct {
+		xml string
+		err error
+	}{
+		{`<!-- c -->`, ErrNoRoot},
+		{`<a/><b/>`, ErrManyRoots},
+		{`<a/><![CDATA[ ]]>`, ErrOutside},
+	} {
+		if _, err := ParseDocument(tc.xml); err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.xml, err, tc.err)
+		}
+	}
+	if _, err := ParseDocument("\n<a/>\n", TextSpace(SpacePreserve)); err != nil {
+		t.Errorf("white space outside the root: %v", err)
+	}
+	if _, err := ParseDocumentReader(strings.NewReader(xml)); err != nil {
+		t.Errorf("reader: %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	const xml = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<!DOCTYPE r [<!ENTITY e "&lt;ent&gt;">]>
+<!--head-->
+<r a="x &amp; &quot;y&quot; &lt;z&gt;" b='it&apos;s' c="&#9;t&#10;n&#13;">&e; &amp; 3 &gt; 2<?go fast?><s/><![CDATA[<raw> & ]]]]><![CDATA[>]]><!-- c <> --><t>a&#13;b</t></r>
+<?tail?>`
+	tree, err := ParseXml(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0"?><!DOCTYPE r [<!ENTITY e "&lt;ent&gt;">]><!--head-->` +
+		`<r a="x &amp; &quot;y&quot; &lt;z&gt;" b="it's" c="&#9;t&#10;n&#13;">&lt;ent&gt; &amp; 3 &gt; 2` +
+		`<?go fast?><s/><![CDATA[<raw> & ]]]]><![CDATA[>]]><!-- c <> --><t>a&#13;b</t></r><?tail?>`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// the output parses back into the same tree
+	tree2, err := ParseXml(string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var show1, show2 strings.Builder
+	ShowXml(tree, &show1, -1)
+	ShowXml(tree2, &show2, -1)
+	// only the encoding of the input is not written
+	if strings.Replace(show1.String(), " encoding=ISO-8859-1", "", 1) != show2.String() {
+		t.Errorf("reparsed tree differs:\n%v\n%v", show1.String(), show2.String())
+	}
+	r := tree2.ChildElements()[0]
+	for _, k := range []string{"a", "b", "c"} {
+		v1, _ := tree.ChildElements()[0].Attr(k)
+		if v2, _ := r.Attr(k); v1 != v2 {
+			t.Errorf("property %v: got %q, want %q", k, v2, v1)
+		}
+	}
+
+	doc, err := ParseDocument(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if n, err := doc.WriteTo(&b); err != nil || n != int64(len(want)) || b.String() != want {
+		t.Errorf("document: got %d %v\n%v", n, err, b.String())
+	}
+
+	// prefixes declared outside the written subtree are declared again
+	ns, err := ParseXml(`<r xmlns="urn:d" xmlns:p="urn:p" xmlns:q="urn:q"><p:s q:k="1"><t/></p:s><u xmlns:p="urn:p2"/></r>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ns.FirstChild().FirstChild()
+	if out, err := Marshal(s); err != nil ||
+		string(out) != `<p:s q:k="1" xmlns:p="urn:p" xmlns:q="urn:q"><t xmlns="urn:d"/></p:s>` {
+		t.Errorf("subtree: got %s %v", out, err)
+	} else if s2, err := ParseXml(string(out)); err != nil || s2.FirstChild().FirstChild().QName() != s.FirstChild().QName() {
+		t.Errorf("subtree reparsed: %v", err)
+	}
+	if out, err := Marshal(ns); err != nil || !strings.HasPrefix(string(out), `<r xmlns="urn:d" xmlns:p="urn:p" xmlns:q="urn:q"><p:s q:k="1">`) {
+		t.Errorf("namespaces: got %s %v", out, err)
+	}
+	p := NewElement("p:new")
+	if err := s.AppendChild(p); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Marshal(ns); err != nil || !strings.Contains(string(out), "<p:new/>") {
+		t.Errorf("added element: got %s %v", out, err)
+	}
+
+	// a built tree
+	e := NewElement("p")
+	e.SetAttr("q", "a<b\n")
+	e.AppendChild(NewText("x]]>y"))
+	e.AppendChild(NewComment("ok"))
+	if out, err := Marshal(e); err != nil || string(out) != `<p q="a&lt;b&#10;">x]]&gt;y<!--ok--></p>` {
+		t.Errorf("built: got %s %v", out, err)
+	}
+
+	for _, n := range []*XmlNode{
+		NewComment("a--b"),
+		NewComment("a-"),
+		NewText("a\x00"),
+		NewElement("1a"),
+		NewElement("p:x"),
+		NewElement("xmlns:x"),
+		{ntype: XN_Tag, name: "a", prop: []*XmlNode{{ntype: XN_Prop, name: "q:k"}}},
+		{ntype: XN_PI, name: "xml"},
+		{ntype: XN_PI, name: "p", value: "?>"},
+		{ntype: XN_Prop, name: "k"},
+	} {
+		if out, err := Marshal(n); err == nil {
+			t.Errorf("%+v: got %s", n, out)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 type XmlNodeType int
@@ -16,22 +17,204 @@ const (
 	XN_Text
 	XN_Property
 	XN_Comment
+	XN_CData
+	XN_PI
+	XN_Doctype
 )
 
 type XmlNode struct {
-	ntype XmlNodeType
-	name  string // elem name, property key
-	value string // property value, text
-	prop  []*XmlNode
-	sube  []*XmlNode
+	ntype  XmlNodeType
+	name   string // elem name, property key
+	value  string // property value, text
+	prop   []*XmlNode
+	sube   []*XmlNode
+	dtd    *Doctype // parsed DOCTYPE of an XN_Doctype node
+	qname  QName    // resolved name of an XN_Tag or XN_Prop node
+	parent *XmlNode
 }
 
-func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
+// QName returns the namespace-qualified name of an element or property
+// node.
+func (node *XmlNode) QName() QName {
+	return node.qname
+}
+
+// Type returns the type of the node.
+func (node *XmlNode) Type() XmlNodeType {
+	return node.ntype
+}
+
+// Name returns the name of an element, the key of a property, the
+// target of a processing instruction or the root element name of a
+// DOCTYPE, and "" for other nodes.
+func (node *XmlNode) Name() string {
+	switch node.ntype {
+	case XN_Text, XN_Comment, XN_CData, XN_Dummy:
+		return ""
+	}
+	return node.name
+}
+
+// Attr is a property of an element.
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// Attrs returns the properties of an element in document order.
+func (node *XmlNode) Attrs() []Attr {
+	attrs := make([]Attr, len(node.prop))
+	for i, p := range node.prop {
+		attrs[i] = Attr{p.name, p.value}
+	}
+	return attrs
+}
+
+// Attr returns the value of the property name and whether the node has
+// the property.
+func (node *XmlNode) Attr(name string) (string, bool) {
+	for _, p := range node.prop {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// Children returns the child nodes in document order.
+func (node *XmlNode) Children() []*XmlNode {
+	return append([]*XmlNode(nil), node.sube...)
+}
+
+// ChildElements returns the child elements in document order.
+func (node *XmlNode) ChildElements() []*XmlNode {
+	var elems []*XmlNode
+	for _, n := range node.sube {
+		if n.ntype == XN_Tag {
+			elems = append(elems, n)
+		}
+	}
+	return elems
+}
+
+// Text returns the content of a text, CDATA or comment node, the data
+// of a processing instruction, the value of a property, and for other
+// nodes the text and CDATA of their children joined.
+func (node *XmlNode) Text() string {
+	switch node.ntype {
+	case XN_Text, XN_CData, XN_Comment:
+		return node.name
+	case XN_PI, XN_Prop:
+		return node.value
+	}
+
+	var b strings.Builder
+	for _, n := range node.sube {
+		if n.ntype == XN_Text || n.ntype == XN_CData {
+			b.WriteString(n.name)
+		}
+	}
+	return b.String()
+}
+
+// InnerText returns the text and CDATA of the node and all its
+// descendants joined in document order.
+func (node *XmlNode) InnerText() string {
+	var b strings.Builder
+	node.innerText(&b)
+	return b.String()
+}
+
+func (node *XmlNode) innerText(b *strings.Builder) {
+	switch node.ntype {
+	case XN_Text, XN_CData:
+		b.WriteString(node.name)
+	case XN_Tag, XN_Dummy:
+		for _, n := range node.sube {
+			n.innerText(b)
+		}
+	}
+}
+
+func (node *XmlNode) hasElement() bool {
+	for _, n := range node.sube {
+		if n.ntype == XN_Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends the child n to node.
+func (node *XmlNode) add(n *XmlNode) {
+	n.parent = node
+	node.sube = append(node.sube, n)
+}
+
+func (node *XmlNode) addProp(key, value string) {
+	node.prop = append(node.prop, &XmlNode{ntype: XN_Prop, name: key, value: value, parent: node})
+}
+
+// newHead returns the XN_Head node of the XML declaration decl.
+func newHead(decl *XmlDecl) *XmlNode {
+	hd := &XmlNode{}
+	hd.ntype = XN_Head
+	hd.name = "xml"
+	hd.addProp("version", decl.Version)
+	if decl.Encoding != "" {
+		hd.addProp("encoding", decl.Encoding)
+	}
+	if decl.Standalone != "" {
+		hd.addProp("standalone", decl.Standalone)
+	}
+	return hd
+}
+
+// Decl returns the XML declaration of the document parsed into the
+// tree node, or nil if the document has none.
+func (node *XmlNode) Decl() *XmlDecl {
+	hd := node
+	if hd.ntype == XN_Dummy && len(hd.sube) > 0 {
+		hd = hd.sube[0]
+	}
+	if hd.ntype != XN_Head {
+		return nil
+	}
+
+	decl := &XmlDecl{}
+	for _, p := range hd.prop {
+		switch p.name {
+		case "version":
+			decl.Version = p.value
+		case "encoding":
+			decl.Encoding = p.value
+		case "standalone":
+			decl.Standalone = p.value
+		}
+	}
+	return decl
+}
+
+// Doctype returns the DOCTYPE declaration of the document parsed into
+// the tree node, or nil if the document has none.
+func (node *XmlNode) Doctype() *Doctype {
+	if node.ntype == XN_Doctype {
+		return node.dtd
+	}
+	for _, n := range node.sube {
+		if n.ntype == XN_Doctype {
+			return n.dtd
+		}
+	}
+	return nil
+}
+
+func buildTree(scanner NsScanner, parent *XmlNode) (*XmlNode, error) {
 	if parent == nil {
 		parent = &XmlNode{ntype: XN_Dummy}
 	}
 
-	var tk XmlToken
+	var tk NsToken
 	var err error
 
 	for {
@@ -46,18 +229,21 @@ func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
 		switch tk.ID {
 		case XML_HEAD:
 			if parent.ntype != XN_Dummy {
-				return parent, fmt.Errorf("invalid xml header: %v", tk.Val)
+				return parent, &SyntaxError{Pos: tk.Start, Msg: "invalid xml header",
+					Found: "<?" + tk.Val + "?>"}
 			}
-			hd := &XmlNode{}
-			hd.ntype = XN_Head
-			hd.name = tk.Val
-			parent.sube = append(parent.sube, hd)
+			decl, err := parseXmlDecl(tk.Val, tk.Start.advance("<?"))
+			if err != nil {
+				return parent, err
+			}
+			parent.add(newHead(decl))
 
 		case XML_TAG_OPTN:
 			otag := &XmlNode{}
 			otag.ntype = XN_Tag
 			otag.name = tk.Val
-			parent.sube = append(parent.sube, otag)
+			otag.qname = tk.Name
+			parent.add(otag)
 			if _, err := buildTree(scanner, otag); err != nil {
 				return parent, err
 			}
@@ -66,6 +252,8 @@ func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
 			pkey := &XmlNode{}
 			pkey.ntype = XN_Prop
 			pkey.name = tk.Val
+			pkey.qname = tk.Name
+			pkey.parent = parent
 			parent.prop = append(parent.prop, pkey)
 			if _, err := buildTree(scanner, pkey); err != nil {
 				return parent, err
@@ -73,7 +261,8 @@ func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
 
 		case XML_PRO_VAL:
 			if parent.ntype != XN_Prop {
-				return parent, fmt.Errorf("invaild property: %v", tk.Val)
+				return parent, &SyntaxError{Pos: tk.Start, Msg: "invalid property",
+					Found: tk.Val}
 			}
 			parent.value = tk.Val
 			return parent, nil
@@ -82,17 +271,50 @@ func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
 			txt := &XmlNode{}
 			txt.ntype = XN_Text
 			txt.name = tk.Val
-			parent.sube = append(parent.sube, txt)
+			parent.add(txt)
 
 		case XML_COMMENT:
 			cm := &XmlNode{}
 			cm.ntype = XN_Comment
 			cm.name = tk.Val
-			parent.sube = append(parent.sube, cm)
+			parent.add(cm)
+
+		case XML_PI:
+			pi := &XmlNode{}
+			pi.ntype = XN_PI
+			pi.name, pi.value = splitPI(tk.Val)
+			parent.add(pi)
+
+		case XML_DOCTYPE:
+			if parent.ntype != XN_Dummy || parent.Doctype() != nil || parent.hasElement() {
+				return parent, &SyntaxError{Pos: tk.Start, Msg: "misplaced DOCTYPE",
+					Found: "<!DOCTYPE"}
+			}
+			dtd, err := parseDoctype(tk.Val, tk.Start.advance("<!DOCTYPE"))
+			if err != nil {
+				return parent, err
+			}
+			dt := &XmlNode{}
+			dt.ntype = XN_Doctype
+			dt.name = dtd.Name
+			dt.value = tk.Val
+			dt.dtd = dtd
+			parent.add(dt)
+
+		case XML_CDATA:
+			cd := &XmlNode{}
+			cd.ntype = XN_CData
+			cd.name = tk.Val
+			parent.add(cd)
 
 		case XML_TAG_CLOSE:
 			if parent.name != tk.Val {
-				return parent, fmt.Errorf("invalid close tag: %v", tk.Val)
+				e := &SyntaxError{Pos: tk.Start, Msg: "invalid close tag",
+					Found: "</" + tk.Val + ">"}
+				if parent.ntype == XN_Tag {
+					e.Expected = "</" + parent.name + ">"
+				}
+				return parent, e
 			}
 			return parent, nil
 
@@ -104,9 +326,22 @@ func buildTree(scanner XmlScanner, parent *XmlNode) (*XmlNode, error) {
 	return parent, err
 }
 
-func ParseXml(xml string) (tree *XmlNode, err error) {
-	scan := scanXml(xml)
-	return buildTree(scan, nil)
+func ParseXml(xml string, opts ...Option) (tree *XmlNode, err error) {
+	return parse(scanXml(xml, opts...), opts)
+}
+
+// ParseReader parses the XML document read from r. The input is read
+// through a bounded buffer, so r need not fit in memory as a whole.
+func ParseReader(r io.Reader, opts ...Option) (tree *XmlNode, err error) {
+	return parse(NewScanner(r, opts...), opts)
+}
+
+func parse(scan XmlScanner, opts []Option) (*XmlNode, error) {
+	nscan := NewNsScanner(scan)
+	if newOptions(opts).strict {
+		nscan = checkWellFormed(nscan)
+	}
+	return buildTree(nscan, nil)
 }
 
 func ShowXml(node *XmlNode, w io.Writer, lvl int) {
@@ -121,7 +356,11 @@ func ShowXml(node *XmlNode, w io.Writer, lvl int) {
 	switch node.ntype {
 	case XN_Dummy:
 	case XN_Head:
-		fmt.Fprintf(w, "<? %v ?>\n", node.name)
+		fmt.Fprintf(w, "<?%v", node.name)
+		for i := 0; i < len(node.prop); i++ {
+			fmt.Fprintf(w, " %v=%v", node.prop[i].name, node.prop[i].value)
+		}
+		fmt.Fprintf(w, "?>\n")
 		return
 	case XN_Tag:
 		fmt.Fprintf(w, "<%v", node.name)
@@ -146,6 +385,19 @@ func ShowXml(node *XmlNode, w io.Writer, lvl int) {
 	case XN_Comment:
 		fmt.Fprintf(w, "<!-- %v -->\n", node.name)
 		return
+	case XN_Doctype:
+		fmt.Fprintf(w, "<!DOCTYPE%v>\n", node.value)
+		return
+	case XN_PI:
+		if node.value == "" {
+			fmt.Fprintf(w, "<?%v?>\n", node.name)
+		} else {
+			fmt.Fprintf(w, "<?%v %v?>\n", node.name, node.value)
+		}
+		return
+	case XN_CData:
+		fmt.Fprintf(w, "<![CDATA[%v]]>\n", node.name)
+		return
 	}
 
 	for i := 0; i < len(node.sube); i++ {
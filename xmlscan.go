@@ -2,7 +2,8 @@ package xmlparser
 
 import (
 	"bytes"
-	"fmt"
+	"io"
+	"strings"
 )
 
 const (
@@ -13,25 +14,44 @@ const (
 	XML_PRO_KEY              // <xx KEY=v1>
 	XML_PRO_VAL              // <xx k1=VALUE>
 	XML_COMMENT              // <!-- ... -->
+	XML_CDATA                // <![CDATA[ ... ]]>
+	XML_PI                   // <?target ...?>
+	XML_DOCTYPE              // <!DOCTYPE ...>
 )
 
+const cdataStart = "CDATA[" // follows "<!["
+
 type XmlToken struct {
-	ID  int
-	Val string
+	ID    int
+	Val   string
+	Start Pos // position of the first character of the token
+	End   Pos // position just past the last character of the token
 }
 
 type XmlScanner func() (XmlToken, error)
 
-func scanXml(xml string) XmlScanner {
-	xmlr := bytes.NewReader([]byte(xml))
+func scanXml(xml string, opts ...Option) XmlScanner {
+	return NewScanner(strings.NewReader(xml), opts...)
+}
+
+// NewScanner returns a scanner which reads the XML document from r
+// through a bounded buffer.
+func NewScanner(r io.Reader, opts ...Option) XmlScanner {
+	opt := newOptions(opts)
+	xmlr := newXmlReader(r, opt)
 
 	var val bytes.Buffer
 	nextToken := XmlToken{}
 	fgStopped := false
 	fgStarted := false
 	var errAction error
-	var syntaxErrOff int64
-	var hasHeader bool
+	var errState string
+	var tkStart Pos
+	var atStart bool // the markup being scanned is the first of the document
+	var quote rune
+	var tagName string
+	var propKey string // name of the property being scanned
+	var depth int      // number of open elements
 
 	const (
 		l_start int = iota
@@ -43,22 +63,46 @@ func scanXml(xml string) XmlScanner {
 		l_h3
 		l_ct1
 		l_ct2
+		l_ct3
 		l_ot1
 		l_ot2
 		l_tt
 		l_pt1
+		l_pe
 		l_pt2
+		l_pq
+		l_pt3
+		l_pt4
+		l_et
 		l_cm1
 		l_cm2
 		l_cm3
 		l_cm4
 		l_cm5
 		l_cm6
+		l_cd1
+		l_cd2
+		l_cd3
+		l_cd4
+		l_dt
 	)
 
 	var nextgoto int = l_start
 
-	return XmlScanner(func() (XmlToken, error) {
+	// decode unescapes a text or property value starting at start.
+	decode := func(s string, start Pos) (string, error) {
+		if !opt.decode {
+			return s, nil
+		}
+		return xmlr.unescape(s, start)
+	}
+
+	// decodeValue unescapes and normalizes the value of property propKey.
+	decodeValue := func(s string, start Pos) (string, error) {
+		return xmlr.propValue(s, start, tagName, propKey)
+	}
+
+	return textSpace(XmlScanner(func() (XmlToken, error) {
 		if fgStopped {
 			return XmlToken{}, errAction
 		}
@@ -87,6 +131,8 @@ func scanXml(xml string) XmlScanner {
 			goto S_ct1
 		case l_ct2:
 			goto S_ct2
+		case l_ct3:
+			goto S_ct3
 		case l_ot1:
 			goto S_ot1
 		case l_ot2:
@@ -95,8 +141,18 @@ func scanXml(xml string) XmlScanner {
 			goto S_tt
 		case l_pt1:
 			goto S_pt1
+		case l_pe:
+			goto S_pe
 		case l_pt2:
 			goto S_pt2
+		case l_pq:
+			goto S_pq
+		case l_pt3:
+			goto S_pt3
+		case l_pt4:
+			goto S_pt4
+		case l_et:
+			goto S_et
 		case l_cm1:
 			goto S_cm1
 		case l_cm2:
@@ -109,6 +165,16 @@ func scanXml(xml string) XmlScanner {
 			goto S_cm5
 		case l_cm6:
 			goto S_cm6
+		case l_cd1:
+			goto S_cd1
+		case l_cd2:
+			goto S_cd2
+		case l_cd3:
+			goto S_cd3
+		case l_cd4:
+			goto S_cd4
+		case l_dt:
+			goto S_dt
 		default:
 			panic("no entry")
 		}
@@ -119,10 +185,12 @@ func scanXml(xml string) XmlScanner {
 				errAction = err
 				goto S_err
 			} else if c == '<' {
+				atStart = true
 				goto S_lt
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "start"
 				goto S_serr
 			}
 		}
@@ -131,49 +199,46 @@ func scanXml(xml string) XmlScanner {
 		goto S_return
 
 	S_serr:
-		syntaxErrOff = xmlr.Size() - int64(xmlr.Len())
-		if syntaxErrOff < xmlr.Size() {
-			tmp := xml[syntaxErrOff:]
-			if len(tmp) > 16 {
-				tmp = tmp[:16]
-			}
-			errAction = fmt.Errorf("syntax error: at %v, before %v",
-				syntaxErrOff, tmp)
-		}
-
+		errAction = xmlr.syntaxError(errState)
 		goto S_return
 
 	S_lt:
+		tkStart = xmlr.lastPos()
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "lt"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '?' {
-				if !hasHeader {
-					hasHeader = true
-					goto S_h1 //sm.Feed(E_qes)
-				} else {
-					goto S_serr //sm.Feed(E_serr)
-				}
+				goto S_h1 //sm.Feed(E_qes)
 			} else if c == '/' {
+				atStart = false
 				goto S_ct1 //sm.Feed(E_sl)
 			} else if c == '!' {
+				atStart = false
 				goto S_cm1 //sm.Feed(E_gth)
-			} else {
+			} else if isNameStartChar(c) {
+				atStart = false
 				val.WriteRune(c)
 				goto S_ot1 //sm.Feed(E_oc)
+			} else {
+				errState = "lt"
+				goto S_serr
 			}
 		}
 
 	S_h1:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "h1"
+				goto S_serr // unterminated processing instruction
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '?' {
 				goto S_h2
-			} else if c == '>' || c == '<' {
-				goto S_serr
 			} else {
 				val.WriteRune(c)
 			}
@@ -181,15 +246,23 @@ func scanXml(xml string) XmlScanner {
 
 	S_h2:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "h2"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '>' {
-				nextToken = XmlToken{XML_HEAD, val.String()}
+				nextToken = XmlToken{XML_PI, val.String(), tkStart, xmlr.Pos()}
+				nextToken.ID, errAction = xmlr.procInst(nextToken.Val, tkStart, atStart)
+				atStart = false
 				val.Reset()
 				nextgoto = l_h3
 				goto S_return
+			} else if c == '?' {
+				val.WriteRune('?')
 			} else {
+				val.WriteRune('?')
 				val.WriteRune(c)
 				goto S_h1
 			}
@@ -200,28 +273,73 @@ func scanXml(xml string) XmlScanner {
 			if c, _, err := xmlr.ReadRune(); err != nil {
 				errAction = err
 				goto S_err
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextgoto = l_lt
+				goto S_return
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				goto S_lt
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					goto S_tt
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "h3"
 				goto S_serr
 			}
 		}
 
 	S_ct1:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ct1"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
-			} else if c == '>' {
-				nextToken = XmlToken{XML_TAG_CLOSE, val.String()}
+			} else if c == '>' && val.Len() > 0 {
+				nextToken = XmlToken{XML_TAG_CLOSE, val.String(), tkStart, xmlr.Pos()}
+				depth--
 				val.Reset()
 				nextgoto = l_ct2
 				goto S_return
-			} else {
+			} else if isSpace(c) && val.Len() > 0 {
+				goto S_ct3
+			} else if isNameChar(c) && (val.Len() > 0 || isNameStartChar(c)) {
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "ct1"
+				goto S_serr
+			}
+		}
+
+	S_ct3:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ct3"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '>' {
+				nextToken = XmlToken{XML_TAG_CLOSE, val.String(), tkStart, xmlr.Pos()}
+				depth--
+				val.Reset()
+				nextgoto = l_ct2
+				goto S_return
+			} else if !isSpace(c) {
+				errState = "ct3"
+				goto S_serr
 			}
 		}
 
@@ -230,33 +348,66 @@ func scanXml(xml string) XmlScanner {
 			if c, _, err := xmlr.ReadRune(); err != nil {
 				errAction = err
 				goto S_err
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextgoto = l_lt
+				goto S_return
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				goto S_lt
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					goto S_tt
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
 				continue
 			} else {
+				errState = "ct2"
 				goto S_serr
 			}
 		}
 
 	S_ot1:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "ot1"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '>' {
-				nextToken = XmlToken{XML_TAG_OPTN, val.String()}
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
 				val.Reset()
 				nextgoto = l_ot2
 				goto S_return
-			} else if c == ' ' {
-				nextToken = XmlToken{XML_TAG_OPTN, val.String()}
+			} else if isSpace(c) {
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
 				val.Reset()
 				nextgoto = l_pt1
 				goto S_return
-			} else {
+			} else if c == '/' {
+				tagName = val.String()
+				nextToken = XmlToken{XML_TAG_OPTN, tagName, tkStart, xmlr.lastPos()}
+				depth++
+				val.Reset()
+				nextgoto = l_et
+				goto S_return
+			} else if isNameChar(c) {
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "ot1"
+				goto S_serr
 			}
 		}
 
@@ -265,13 +416,28 @@ func scanXml(xml string) XmlScanner {
 			if c, _, err := xmlr.ReadRune(); err != nil {
 				errAction = err
 				goto S_err
-			} else if c == '\n' || c == '\r' || c == '\t' {
-				goto S_ot2
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextgoto = l_lt
+				goto S_return
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				goto S_lt
-			} else {
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
 				val.WriteRune(c)
-				goto S_tt
+				if !isSpace(c) {
+					goto S_tt
+				}
+			} else if isSpace(c) {
+				continue
+			} else {
+				errState = "ot2"
+				goto S_serr
 			}
 		}
 
@@ -281,7 +447,8 @@ func scanXml(xml string) XmlScanner {
 				errAction = err
 				goto S_err
 			} else if c == '<' {
-				nextToken = XmlToken{XML_TEXT, val.String()}
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decode(nextToken.Val, tkStart)
 				val.Reset()
 				nextgoto = l_lt
 				goto S_return
@@ -293,72 +460,224 @@ func scanXml(xml string) XmlScanner {
 
 	S_pt1:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt1"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
-			} else if c == '=' {
-				nextToken = XmlToken{XML_PRO_KEY, val.String()}
+			} else if c == '=' && val.Len() > 0 {
+				nextToken = XmlToken{XML_PRO_KEY, val.String(), tkStart, xmlr.lastPos()}
+				propKey = nextToken.Val
 				val.Reset()
 				nextgoto = l_pt2
 				goto S_return
-			} else {
+			} else if isSpace(c) && val.Len() == 0 {
+				continue
+			} else if isSpace(c) {
+				nextToken = XmlToken{XML_PRO_KEY, val.String(), tkStart, xmlr.lastPos()}
+				propKey = nextToken.Val
+				val.Reset()
+				nextgoto = l_pe
+				goto S_return
+			} else if c == '/' && val.Len() == 0 {
+				goto S_et
+			} else if c == '>' && val.Len() == 0 {
+				goto S_ot2
+			} else if isNameChar(c) && (val.Len() > 0 || isNameStartChar(c)) {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
 				val.WriteRune(c)
 				continue
+			} else {
+				errState = "pt1"
+				goto S_serr
+			}
+		}
+
+	S_pe:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pe"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '=' {
+				goto S_pt2
+			} else if !isSpace(c) {
+				errState = "pe"
+				goto S_serr
 			}
 		}
 
 	S_pt2:
+		if val.Len() == 0 {
+			tkStart = xmlr.Pos()
+		}
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt2"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
-			} else if c == ' ' {
-				nextToken = XmlToken{XML_PRO_VAL, val.String()}
+			} else if (c == '"' || c == '\'') && val.Len() == 0 {
+				quote = c
+				goto S_pq
+			} else if isSpace(c) && val.Len() == 0 {
+				tkStart = xmlr.Pos()
+				continue
+			} else if isSpace(c) {
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
 				val.Reset()
 				nextgoto = l_pt1
 				goto S_return
 			} else if c == '>' {
-				nextToken = XmlToken{XML_PRO_VAL, val.String()}
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.lastPos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
 				val.Reset()
 				nextgoto = l_ot2
 				goto S_return
+			} else if c == '/' {
+				goto S_pt4
 			} else {
 				val.WriteRune(c)
 				continue
 			}
 		}
 
+	S_pq:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pq"
+				goto S_serr // unterminated quoted value
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '<' {
+				errState = "pq"
+				goto S_serr // no '<' in property values
+			} else if c == quote {
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.Pos()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart.advance(string(quote)))
+				val.Reset()
+				nextgoto = l_pt3
+				goto S_return
+			} else {
+				val.WriteRune(c)
+				continue
+			}
+		}
+
+	S_pt3:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt3"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if isSpace(c) {
+				goto S_pt1
+			} else if c == '>' {
+				goto S_ot2
+			} else if c == '/' {
+				goto S_et
+			} else {
+				errState = "pt3"
+				goto S_serr
+			}
+		}
+
+	S_pt4:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "pt4"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '>' {
+				xmlr.UnreadRune()
+				nextToken = XmlToken{XML_PRO_VAL, val.String(), tkStart, xmlr.Pos().back()}
+				nextToken.Val, errAction = decodeValue(nextToken.Val, tkStart)
+				val.Reset()
+				nextgoto = l_et
+				goto S_return
+			} else {
+				val.WriteRune('/')
+				xmlr.UnreadRune()
+				goto S_pt2
+			}
+		}
+
+	S_et:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "et"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '>' {
+				tkStart = xmlr.lastPos().back() // the '/'
+				nextToken = XmlToken{XML_TAG_CLOSE, tagName, tkStart, xmlr.Pos()}
+				depth--
+				nextgoto = l_ct2
+				goto S_return
+			} else {
+				errState = "et"
+				goto S_serr
+			}
+		}
+
 	S_cm1:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm1"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '-' {
 				goto S_cm2
+			} else if c == '[' {
+				goto S_cd1
+			} else if c == 'D' {
+				goto S_dt
 			} else {
+				errState = "cm1"
 				goto S_serr
 			}
 		}
 	S_cm2:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm2"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '-' {
 				goto S_cm3
 			} else {
+				errState = "cm2"
 				goto S_serr
 			}
 		}
 	S_cm3:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm3"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '-' {
 				goto S_cm4
-			} else if c == '<' || c == '>' {
-				goto S_serr
 			} else {
 				val.WriteRune(c)
 				continue
@@ -366,7 +685,10 @@ func scanXml(xml string) XmlScanner {
 		}
 	S_cm4:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm4"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '-' {
@@ -379,14 +701,23 @@ func scanXml(xml string) XmlScanner {
 		}
 	S_cm5:
 		for {
-			if c, _, err := xmlr.ReadRune(); err != nil {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cm5"
+				goto S_serr
+			} else if err != nil {
 				errAction = err
 				goto S_err
 			} else if c == '>' {
-				nextToken = XmlToken{XML_COMMENT, val.String()}
+				nextToken = XmlToken{XML_COMMENT, val.String(), tkStart, xmlr.Pos()}
 				val.Reset()
 				nextgoto = l_cm6
 				goto S_return
+			} else if opt.strict {
+				errState = "cm5"
+				goto S_serr // "--" inside the comment
+			} else if c == '-' {
+				val.WriteRune('-')
+				continue
 			} else {
 				val.WriteString("--")
 				val.WriteRune(c)
@@ -399,16 +730,115 @@ func scanXml(xml string) XmlScanner {
 			if c, _, err := xmlr.ReadRune(); err != nil {
 				errAction = err
 				goto S_err
+			} else if c == '<' && val.Len() > 0 && !xmlr.dropped(val.Bytes()) {
+				// white space between elements
+				nextToken = XmlToken{XML_TEXT, val.String(), tkStart, xmlr.lastPos()}
+				val.Reset()
+				nextgoto = l_lt
+				goto S_return
 			} else if c == '<' {
+				val.Reset() // white space the policy drops, if any
 				goto S_lt
+			} else if depth > 0 {
+				if val.Len() == 0 {
+					tkStart = xmlr.lastPos()
+				}
+				val.WriteRune(c)
+				if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					goto S_tt
+				}
 			} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
-				//goto S_cm6
+				continue
+			} else {
+				errState = "cm6"
+				goto S_serr
+			}
+		}
+
+	S_cd1:
+		for i := 0; i < len(cdataStart); i++ {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd1"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c != rune(cdataStart[i]) {
+				errState = "cd1"
+				goto S_serr
+			}
+		}
+		goto S_cd2
+
+	S_cd2:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd2"
+				goto S_serr // unterminated CDATA section
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == ']' {
+				goto S_cd3
+			} else {
+				val.WriteRune(c)
+				continue
+			}
+		}
+	S_cd3:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd3"
+				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == ']' {
+				goto S_cd4
 			} else {
+				val.WriteRune(']')
+				val.WriteRune(c)
+				goto S_cd2
+			}
+		}
+	S_cd4:
+		for {
+			if c, _, err := xmlr.ReadRune(); err == io.EOF {
+				errState = "cd4"
 				goto S_serr
+			} else if err != nil {
+				errAction = err
+				goto S_err
+			} else if c == '>' {
+				nextToken = XmlToken{XML_CDATA, val.String(), tkStart, xmlr.Pos()}
+				val.Reset()
+				nextgoto = l_ot2
+				goto S_return
+			} else if c == ']' {
+				val.WriteRune(']')
+				continue
+			} else {
+				val.WriteString("]]")
+				val.WriteRune(c)
+				goto S_cd2
 			}
 		}
 
+	S_dt:
+		if raw, err := xmlr.readDoctype(); err != nil {
+			errAction = err
+			goto S_err
+		} else {
+			nextToken = XmlToken{XML_DOCTYPE, raw, tkStart, xmlr.Pos()}
+			errAction = xmlr.doctype(raw, tkStart.advance("<!DOCTYPE"))
+			nextgoto = l_cm6
+			goto S_return
+		}
+
 	S_return:
+		if errAction != nil {
+			fgStopped = true // the scanner cannot resume after an error
+		}
 		return nextToken, errAction
-	})
+	}), xmlr)
 }